@@ -0,0 +1,493 @@
+package towers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Clone returns a deep copy of the board's grid, Allowed lists, observer
+// bookkeeping and history. Perms is shared with the original since it is never
+// modified after initialization, and the RowPerms and ColPerms lists are
+// shared because the trims always replace a list rather than editing it.
+func (b *Board) Clone() *Board {
+	c := *b
+	c.mu = new(sync.RWMutex)
+	c.trail = nil
+	c.frames = nil
+	c.Grid = make([][]int, b.Size)
+	c.Given = make([][]bool, b.Size)
+	c.Allowed = make([][]Set[int], b.Size)
+	for ri := 0; ri < b.Size; ri++ {
+		c.Grid[ri] = make([]int, b.Size)
+		copy(c.Grid[ri], b.Grid[ri])
+		c.Given[ri] = make([]bool, b.Size)
+		copy(c.Given[ri], b.Given[ri])
+		c.Allowed[ri] = make([]Set[int], b.Size)
+		for ci := 0; ci < b.Size; ci++ {
+			c.Allowed[ri][ci] = b.Allowed[ri][ci].Clone()
+		}
+	}
+	c.Observers = append([]*Observer(nil), b.Observers...)
+	c.ObsSorted = append([]*Observer(nil), b.ObsSorted...)
+	c.RowPerms = append([]*[]int(nil), b.RowPerms...)
+	c.ColPerms = append([]*[]int(nil), b.ColPerms...)
+	c.permIndex = b.clonePermIndex()
+	c.History = append([]*MarkRecord(nil), b.History...)
+	c.Undone = append([]*MarkRecord(nil), b.Undone...)
+	if b.Reasons != nil {
+		c.Reasons = make(map[CellKey][]Elimination, len(b.Reasons))
+		for k, v := range b.Reasons {
+			c.Reasons[k] = append([]Elimination(nil), v...)
+		}
+	}
+	return &c
+}
+
+// CopyGrid returns a deep copy of the board's grid.
+func (b *Board) CopyGrid() [][]int {
+	out := make([][]int, b.Size)
+	for ri := range b.Grid {
+		out[ri] = make([]int, b.Size)
+		copy(out[ri], b.Grid[ri])
+	}
+	return out
+}
+
+// FindContradiction looks for a cell whose Allowed list is empty, which means
+// the board can't be solved. Returns the first such cell in row-major order
+// and ok = true, or ok = false if every cell still has a candidate.
+func (b *Board) FindContradiction() (ri, ci int, ok bool) {
+	for ri = 0; ri < b.Size; ri++ {
+		for ci = 0; ci < b.Size; ci++ {
+			if b.Allowed[ri][ci].Len() == 0 {
+				return ri, ci, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// noCandidatesError returns the error reported when the cell at row ri, col
+// ci has no candidates left. It wraps ErrUnsatisfiable.
+func noCandidatesError(ri, ci int) error {
+	return fmt.Errorf("unsolvable: cell (%d, %d) has no candidates: %w", ri, ci, ErrUnsatisfiable)
+}
+
+// contradiction returns an error if some cell has no allowed values or some
+// line has no remaining permutations, meaning the board cannot be solved.
+func (b *Board) contradiction() error {
+	if ri, ci, ok := b.FindContradiction(); ok {
+		return noCandidatesError(ri, ci)
+	}
+	return b.emptyPermsError()
+}
+
+// emptyPermsError returns an error naming the first line with no permutations
+// left, or nil if every line has some or is unconstrained. The error wraps
+// ErrUnsatisfiable.
+func (b *Board) emptyPermsError() error {
+	for i := 0; i < b.Size; i++ {
+		if b.RowPerms[i] != nil && len(*b.RowPerms[i]) == 0 {
+			return fmt.Errorf("unsolvable: no arrangement of row %d fits its clues: %w", i, ErrUnsatisfiable)
+		}
+		if b.ColPerms[i] != nil && len(*b.ColPerms[i]) == 0 {
+			return fmt.Errorf("unsolvable: no arrangement of col %d fits its clues: %w", i, ErrUnsatisfiable)
+		}
+	}
+	return nil
+}
+
+// propagate quietly runs the cheap heuristics until they stop making
+// progress. Returns an error if the board is found to be contradictory.
+func (b *Board) propagate() error {
+	changed := true
+	for changed {
+		changed = false
+		if b.MarkMandatory() {
+			changed = true
+		}
+		if b.TrimAllowedFromPerms() {
+			changed = true
+		}
+		if b.TrimPermsFromAllowed() {
+			changed = true
+		}
+		if b.Perms == nil {
+			// Without permutations, the trims above do nothing, so
+			// fall back on the checks that don't need them. The dual
+			// observer trim is the closest thing to the perm trims but
+			// costs more, so it only runs once the cheap ones stall.
+			if b.MarkHiddenSingles() {
+				changed = true
+			}
+			if b.TrimByVisibility() {
+				changed = true
+			}
+			if !changed && b.TrimByDualObserver() {
+				changed = true
+			}
+		}
+		if err := b.contradiction(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TrimByTrial is a limited look-ahead. For each empty cell with exactly two
+// candidates, it tries placing each one on a throwaway clone and runs
+// propagate; a candidate that leads straight to a contradiction is removed
+// from the real board. Returns true iff at least one candidate was removed.
+func (b *Board) TrimByTrial() bool {
+	changed := false
+	for ri := 0; ri < b.Size && !b.timeUp(); ri++ {
+		for ci := 0; ci < b.Size; ci++ {
+			if b.Get(ri, ci) != EMPTY || b.CandidateCount(ri, ci) != 2 {
+				continue
+			}
+			for _, v := range b.Candidates(ri, ci) {
+				c := b.Clone()
+				c.Verbose = false
+				c.Reasons = nil
+				c.Mark(ri, ci, v)
+				if c.propagate() == nil {
+					continue
+				}
+				b.disallow(ri, ci, v)
+				b.because("TrimByTrial", "placing %d at (%d, %d) leads to a contradiction", v, ri, ci)
+				b.noteElim(ri, ci, v)
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// A searcher holds the state for a backtracking search. visit is called with
+// each solved board found, and the search stops as soon as it returns false
+// or ctx, if set, is canceled.
+// guesses counts the number of values tried at branch points. If trace is set,
+// path holds the guesses leading to the current branch, and deepest and
+// deepestErr record the longest path that ended in a contradiction.
+type searcher struct {
+	ctx        context.Context
+	visit      func(*Board) bool
+	place      func(*Board)
+	guesses    int
+	budget     int
+	exhausted  bool
+	trace      bool
+	path       []Guess
+	deepest    []Guess
+	deepestErr error
+}
+
+// noteContradiction records err as the contradiction that ended the current
+// branch if the branch is the deepest one seen so far.
+func (s *searcher) noteContradiction(err error) {
+	if s.deepestErr == nil || len(s.path) > len(s.deepest) {
+		s.deepest = append([]Guess(nil), s.path...)
+		s.deepestErr = err
+	}
+}
+
+// search runs a depth-first backtracking search over the board. Each guess is
+// tried in place between Push and Pop, so visit and place must copy anything
+// they want to keep. The board passed to search is left with the propagation
+// done at the top level; callers should pass a clone. Returns false iff the
+// search was stopped by visit.
+func (s *searcher) search(b *Board) bool {
+	if s.ctx != nil && s.ctx.Err() != nil {
+		return false
+	}
+	if err := b.propagate(); err != nil {
+		if s.trace {
+			s.noteContradiction(err)
+		}
+		return true
+	}
+	if b.NumEmpty == 0 {
+		if err := b.Solved(); err != nil {
+			if s.trace {
+				s.noteContradiction(err)
+			}
+			return true
+		}
+		return s.visit(b)
+	}
+	bestR, bestC := b.branchCell()
+	for _, n := range b.guessOrder(bestR, bestC) {
+		if s.budget > 0 && s.guesses >= s.budget {
+			s.exhausted = true
+			return false
+		}
+		s.guesses++
+		b.Push()
+		b.Mark(bestR, bestC, n)
+		if s.place != nil {
+			s.place(b)
+		}
+		if s.trace {
+			s.path = append(s.path, Guess{Row: bestR, Col: bestC, Value: n})
+		}
+		ok := s.search(b)
+		if s.trace {
+			s.path = s.path[:len(s.path)-1]
+		}
+		b.Pop()
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// branchCell returns the empty cell with the fewest candidates, the first in
+// row-major order on ties, which is where the search makes its next guess.
+// The board must have at least one empty cell.
+func (b *Board) branchCell() (int, int) {
+	bestR, bestC := -1, -1
+	for ri := 0; ri < b.Size; ri++ {
+		for ci := 0; ci < b.Size; ci++ {
+			if b.Get(ri, ci) != EMPTY {
+				continue
+			}
+			if bestR < 0 || b.Allowed[ri][ci].Len() < b.Allowed[bestR][bestC].Len() {
+				bestR, bestC = ri, ci
+			}
+		}
+	}
+	return bestR, bestC
+}
+
+// A GuessOrder sets the order in which the backtracking search tries the
+// candidates of the cell it branches on.
+type GuessOrder int
+
+const (
+	// GuessAscending tries the candidates from smallest to largest.
+	GuessAscending GuessOrder = iota
+	// GuessFewestPerms tries first the candidates that appear at the cell's
+	// position in the fewest of its row's and column's remaining
+	// permutations, so the most constrained guesses are tested while they
+	// are cheap to refute.
+	GuessFewestPerms
+	// GuessMostPerms tries first the candidates supported by the most
+	// permutations, which are the likeliest to be right.
+	GuessMostPerms
+)
+
+// guessOrder returns the candidates of the cell at row ri, col ci in the order
+// set by the board's GuessOrder. Ties, and lines without permutation lists,
+// fall back on ascending order.
+func (b *Board) guessOrder(ri, ci int) []int {
+	vals := b.Candidates(ri, ci)
+	if b.GuessOrder == GuessAscending {
+		return vals
+	}
+	support := make([]int, b.Size+1)
+	if b.RowPerms[ri] != nil {
+		for _, pi := range *b.RowPerms[ri] {
+			support[b.Perms[pi][ci]]++
+		}
+	}
+	if b.ColPerms[ci] != nil {
+		for _, pi := range *b.ColPerms[ci] {
+			support[b.Perms[pi][ri]]++
+		}
+	}
+	sort.SliceStable(vals, func(i, j int) bool {
+		if b.GuessOrder == GuessMostPerms {
+			return support[vals[i]] > support[vals[j]]
+		}
+		return support[vals[i]] < support[vals[j]]
+	})
+	return vals
+}
+
+// CountSolutions counts the solutions to the board, stopping once max have
+// been found. The board itself is not modified.
+func (b *Board) CountSolutions(max int) int {
+	count := 0
+	if max <= 0 {
+		return 0
+	}
+	s := searcher{visit: func(*Board) bool {
+		count++
+		return count < max
+	}}
+	c := b.Clone()
+	c.InitPerms()
+	s.search(c)
+	return count
+}
+
+// BruteSolve finds a solution by backtracking search and marks it on the
+// board. Returns an error if the board has no solution.
+func (b *Board) BruteSolve() error {
+	return b.bruteSolve(context.Background(), nil, nil, false)
+}
+
+// bruteSolve implements BruteSolve, adding the number of guesses made to
+// stats if it is non-nil. If place is non-nil, it is called with the search's
+// working board after each guess is placed. If trace is set and the board has
+// no solution, the error is a *ContradictionError holding the deepest branch
+// the search explored. If ctx is canceled before a solution is found, ctx.Err()
+// is returned and the board is left as it was.
+func (b *Board) bruteSolve(ctx context.Context, stats *SolveStats, place func(*Board), trace bool) error {
+	if ri, ci, ok := b.FindContradiction(); ok {
+		return noCandidatesError(ri, ci)
+	}
+	var sol *Board
+	s := searcher{
+		visit: func(s *Board) bool {
+			sol = s.Clone()
+			return false
+		},
+		ctx:   ctx,
+		place: place,
+		trace: trace,
+	}
+	b.InitPerms()
+	s.search(b.Clone())
+	if stats != nil {
+		stats.Guesses += s.guesses
+	}
+	return b.finishSearch(ctx, sol, s.deepest, s.deepestErr)
+}
+
+// finishSearch marks the solution sol found by a search on the board and
+// checks it. If there is none, it returns ctx.Err() if the search was
+// canceled, a *ContradictionError built from deepest and deepestErr if the
+// search traced its contradictions, or else an error wrapping
+// ErrUnsatisfiable.
+func (b *Board) finishSearch(ctx context.Context, sol *Board, deepest []Guess, deepestErr error) error {
+	if err := ctx.Err(); sol == nil && err != nil {
+		return err
+	}
+	if sol == nil && deepestErr != nil {
+		return &ContradictionError{Path: deepest, Err: deepestErr}
+	}
+	if sol == nil {
+		return fmt.Errorf("unsolvable: no solution found by search: %w", ErrUnsatisfiable)
+	}
+	for ri := 0; ri < b.Size; ri++ {
+		for ci := 0; ci < b.Size; ci++ {
+			b.Mark(ri, ci, sol.Get(ri, ci))
+		}
+	}
+	return b.Solved()
+}
+
+// AllSolutions returns up to max solutions to the board, each as a separate
+// copy of the solved grid. The board itself is not modified.
+func (b *Board) AllSolutions(max int) ([][][]int, error) {
+	if max <= 0 {
+		return nil, fmt.Errorf("max must be positive, got %d", max)
+	}
+	out := make([][][]int, 0)
+	s := searcher{visit: func(s *Board) bool {
+		out = append(out, s.CopyGrid())
+		return len(out) < max
+	}}
+	c := b.Clone()
+	c.InitPerms()
+	s.search(c)
+	return out, nil
+}
+
+// stallSearchBudget is the number of guesses stallError's search may make
+// before it gives up counting solutions.
+const stallSearchBudget = 10000
+
+// stalledError is the ErrNeedsGuessing error stallError returns when its
+// search found exactly one solution. It carries the solution, so a caller
+// that goes on to guess can mark it with solveStalled rather than search
+// again.
+type stalledError struct {
+	err      error
+	solution *Board
+}
+
+func (e *stalledError) Error() string { return e.err.Error() }
+
+func (e *stalledError) Unwrap() error { return e.err }
+
+// stallError explains why the heuristics couldn't finish the board by
+// counting its solutions, stopping at two. The error wraps ErrUnsatisfiable,
+// ErrAmbiguous or ErrNeedsGuessing for zero, several or exactly one solution.
+// The count is a search of at most stallSearchBudget guesses; if it runs out,
+// the error is ErrNeedsGuessing itself, and if ctx is canceled, it is
+// ctx.Err(). The board is not modified.
+func (b *Board) stallError(ctx context.Context) error {
+	var first *Board
+	count := 0
+	s := searcher{
+		ctx: ctx,
+		visit: func(s *Board) bool {
+			if count == 0 {
+				first = s.Clone()
+			}
+			count++
+			return count < 2
+		},
+		budget: stallSearchBudget,
+	}
+	c := b.Clone()
+	c.InitPerms()
+	s.search(c)
+	switch {
+	case count >= 2:
+		return fmt.Errorf("stalled with %d empty cells: %w", b.NumEmpty, ErrAmbiguous)
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case s.exhausted:
+		return ErrNeedsGuessing
+	case count == 0:
+		return fmt.Errorf("unsolvable: stalled with %d empty cells: %w", b.NumEmpty, ErrUnsatisfiable)
+	}
+	return &stalledError{
+		err:      fmt.Errorf("stalled with %d empty cells: %w", b.NumEmpty, ErrNeedsGuessing),
+		solution: first,
+	}
+}
+
+// solveStalled finishes a board whose AutoSolve stopped with err, which must
+// wrap ErrNeedsGuessing. The solution stallError found is marked if it kept
+// one; otherwise the board is solved with BruteSolve.
+func (b *Board) solveStalled(err error) error {
+	var se *stalledError
+	if errors.As(err, &se) {
+		return b.finishSearch(context.Background(), se.solution, nil, nil)
+	}
+	return b.BruteSolve()
+}
+
+// An Analysis summarizes how many solutions a puzzle has. Count is 0, 1 or 2,
+// with 2 meaning "two or more". Solutions holds a copy of the grid of each
+// solution found.
+type Analysis struct {
+	Count     int
+	Solutions [][][]int
+}
+
+// Analyze determines whether the board has zero, one or multiple solutions
+// without modifying it. For ambiguous boards, two distinct solutions are
+// returned.
+func (b *Board) Analyze() Analysis {
+	sols, _ := b.AllSolutions(2)
+	return Analysis{Count: len(sols), Solutions: sols}
+}
+
+func (a Analysis) String() string {
+	switch a.Count {
+	case 0:
+		return "puzzle has no solution"
+	case 1:
+		return "puzzle has a unique solution"
+	}
+	return "puzzle has multiple solutions"
+}
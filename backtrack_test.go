@@ -0,0 +1,225 @@
+package towers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// hardPuzzle8 is a size-8 puzzle with every edge clue given that the
+// heuristics can't finish; the search needs hundreds of guesses to find its
+// first solution. Like most fully clued boards of this size it has more than
+// one solution, so the tests and benchmarks stop at the first.
+var hardPuzzle8 = strings.Join([]string{
+	" 31232244 ",
+	"2        4",
+	"2        2",
+	"3        3",
+	"2        3",
+	"4        1",
+	"3        2",
+	"3        2",
+	"1        2",
+	" 13245322 ",
+}, "\n")
+
+// hardBoard8 parses hardPuzzle8 and runs the heuristics on it, leaving it
+// where the search takes over. It fails tb if the puzzle doesn't parse.
+func hardBoard8(tb testing.TB) *Board {
+	tb.Helper()
+	b, err := BoardFromString(hardPuzzle8)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	b.AutoSolve()
+	return b
+}
+
+// cloneSearch is the search the Push/Pop version replaced: it clones the
+// whole board for every guess instead of undoing the guess in place. It
+// returns the first solution below b, or nil.
+func cloneSearch(b *Board) *Board {
+	if b.propagate() != nil {
+		return nil
+	}
+	if b.NumEmpty == 0 {
+		if b.Solved() != nil {
+			return nil
+		}
+		return b
+	}
+	ri, ci := b.branchCell()
+	for _, n := range b.guessOrder(ri, ci) {
+		c := b.Clone()
+		c.Mark(ri, ci, n)
+		if sol := cloneSearch(c); sol != nil {
+			return sol
+		}
+	}
+	return nil
+}
+
+// pushPopSearch runs the searcher on a clone of b and returns the first
+// solution it finds, or nil.
+func pushPopSearch(b *Board) *Board {
+	var sol *Board
+	s := searcher{visit: func(c *Board) bool {
+		sol = c.Clone()
+		return false
+	}}
+	s.search(b.Clone())
+	return sol
+}
+
+func TestSearchMatchesCloneSearch(t *testing.T) {
+	b := hardBoard8(t)
+	if b.NumEmpty == 0 {
+		t.Fatal("the heuristics solved hardPuzzle8 on their own")
+	}
+	want := cloneSearch(b.Clone())
+	got := pushPopSearch(b)
+	if want == nil || got == nil {
+		t.Fatalf("clone search found %v, Push/Pop search found %v", want, got)
+	}
+	if !got.Equals(want) {
+		t.Errorf("Push/Pop search found\n%s\nclone search found\n%s", got, want)
+	}
+}
+
+// BenchmarkSearchClone and BenchmarkSearchPushPop search hardPuzzle8 for its
+// first solution, cloning the board per guess and undoing guesses in place
+// respectively.
+func BenchmarkSearchClone(b *testing.B) {
+	puzzle := hardBoard8(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cloneSearch(puzzle.Clone())
+	}
+}
+
+func BenchmarkSearchPushPop(b *testing.B) {
+	puzzle := hardBoard8(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pushPopSearch(puzzle)
+	}
+}
+
+// BenchmarkGuessOrder searches hardPuzzle8 for its first solution with each
+// GuessOrder.
+func BenchmarkGuessOrder(b *testing.B) {
+	puzzle := hardBoard8(b)
+	for _, order := range []struct {
+		name  string
+		order GuessOrder
+	}{
+		{"Ascending", GuessAscending},
+		{"FewestPerms", GuessFewestPerms},
+		{"MostPerms", GuessMostPerms},
+	} {
+		b.Run(order.name, func(b *testing.B) {
+			c := puzzle.Clone()
+			c.GuessOrder = order.order
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if pushPopSearch(c) == nil {
+					b.Fatal("no solution found")
+				}
+			}
+		})
+	}
+}
+
+func TestPropagateWithoutPermsUsesDualObserver(t *testing.T) {
+	for _, seed := range []int64{1, 7} {
+		puzzle, _ := Generate(7, seed)
+		b, err := BoardFromString(puzzle.ToPuzzleString())
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.NoPerms = true
+		b.Perms = nil
+		for i := 0; i < b.Size; i++ {
+			b.RowPerms[i] = nil
+			b.ColPerms[i] = nil
+		}
+		if err := b.propagate(); err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		if b.TrimByDualObserver() {
+			t.Errorf("seed %d: TrimByDualObserver still trims after propagate without Perms", seed)
+		}
+	}
+}
+
+func TestStallErrorIsBounded(t *testing.T) {
+	// With no heuristics the board stalls straight away, and the count
+	// finds its one solution, which solveStalled marks without searching.
+	b := loadPuzzle(t, "problem6.txt")
+	b.Heuristics = []Heuristic{}
+	err := b.AutoSolve()
+	var se *stalledError
+	if !errors.Is(err, ErrNeedsGuessing) || !errors.As(err, &se) || se.solution == nil {
+		t.Fatalf("AutoSolve with no heuristics returned %v, want ErrNeedsGuessing carrying the solution", err)
+	}
+	if err := b.solveStalled(err); err != nil {
+		t.Fatal(err)
+	}
+	if b.NumEmpty != 0 {
+		t.Errorf("solveStalled left %d cells empty", b.NumEmpty)
+	}
+
+	ref := hardBoard8(t)
+	hard := ref.Clone()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := hard.stallError(ctx); err != context.Canceled {
+		t.Errorf("stallError with a canceled context returned %v", err)
+	}
+
+	found := false
+	s := searcher{visit: func(*Board) bool { found = true; return false }, budget: 5}
+	s.search(hard.Clone())
+	if !s.exhausted || s.guesses != 5 || found {
+		t.Errorf("search with a budget of 5: exhausted %v after %d guesses, solution found %v", s.exhausted, s.guesses, found)
+	}
+	if !hard.Equals(ref) || hard.NumCandidates() != ref.NumCandidates() {
+		t.Error("stallError modified the board")
+	}
+}
+
+func TestSolutionCountsLeaveBoardAlone(t *testing.T) {
+	puzzle, _ := Generate(5, 1)
+	b := NewBoard(5)
+	for _, o := range puzzle.Observers {
+		b.SetEdgeClue(o.Type, o.Index, o.Direction, o.Count)
+	}
+	if b.Perms != nil {
+		t.Fatal("NewBoard with clues already has Perms")
+	}
+	candidates := b.NumCandidates()
+	check := func(what string) {
+		t.Helper()
+		if b.Perms != nil {
+			t.Errorf("%s generated the board's Perms", what)
+		}
+		if n := b.NumCandidates(); n != candidates {
+			t.Errorf("%s left %d candidates, want %d", what, n, candidates)
+		}
+	}
+	if n := b.CountSolutions(2); n != 1 {
+		t.Errorf("CountSolutions = %d, want 1", n)
+	}
+	check("CountSolutions")
+	if sols, err := b.AllSolutions(2); err != nil || len(sols) != 1 {
+		t.Errorf("AllSolutions = %d solutions, %v", len(sols), err)
+	}
+	check("AllSolutions")
+	b.Analyze()
+	check("Analyze")
+	b.Minimize(1)
+	check("Minimize")
+}
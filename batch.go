@@ -0,0 +1,105 @@
+package towers
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A BatchResult records the outcome of solving one puzzle file in a batch.
+// Err holds the parse error if the file couldn't be read or parsed, or the
+// error from AutoSolve if the puzzle wasn't solved.
+type BatchResult struct {
+	Filename string
+	Solved   bool
+	Stats    SolveStats
+	Err      error
+}
+
+// SolveDir walks the directory at path, parses every .txt file as a puzzle and
+// runs AutoSolve on it. A failure in one file, or in a subdirectory that
+// can't be read, is recorded in a result under its path and does not stop the
+// batch; the returned error is only set if the directory at path itself can't
+// be walked. Results are in the order the files were visited, which is
+// lexical order.
+func SolveDir(path string) ([]BatchResult, error) {
+	out := make([]BatchResult, 0)
+	err := walkPuzzles(path, func(p string, b *Board, err error) {
+		res := BatchResult{Filename: p}
+		if err != nil {
+			res.Err = err
+			out = append(out, res)
+			return
+		}
+		stats := NewSolveStats()
+		start := time.Now()
+		res.Err = b.autoSolve(context.Background(), stats)
+		stats.Duration = time.Since(start)
+		res.Solved = res.Err == nil
+		res.Stats = *stats
+		out = append(out, res)
+	})
+	return out, err
+}
+
+// walkPuzzles walks the directory at path in lexical order and calls visit
+// with the name of every .txt file and the board parsed from it, or the error
+// if it couldn't be read or parsed. A subdirectory that can't be read is
+// passed to visit with its error and skipped. The returned error is only set
+// if the directory at path itself can't be walked.
+func walkPuzzles(path string, visit func(p string, b *Board, err error)) error {
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == path {
+				return err
+			}
+			// WalkDir has already skipped the unreadable entry, so
+			// returning nil carries on with its siblings.
+			visit(p, nil, err)
+			return nil
+		}
+		if d.IsDir() || !strings.HasSuffix(p, ".txt") {
+			return nil
+		}
+		b, err := BoardFromFile(p)
+		visit(p, b, err)
+		return nil
+	})
+}
+
+// SolveAll parses and solves each of puzzles with Solve, using workers
+// goroutines (or one per CPU if workers is less than 1). Results are returned
+// in the same order as puzzles. A puzzle that fails to parse gets a result
+// with only Err set.
+func SolveAll(puzzles []string, workers int) []SolveResult {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	results := make([]SolveResult, len(puzzles))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				b, err := BoardFromString(puzzles[j])
+				if err != nil {
+					results[j] = SolveResult{Err: err}
+					continue
+				}
+				results[j] = b.Solve()
+			}
+		}()
+	}
+	for j := range puzzles {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
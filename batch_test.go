@@ -0,0 +1,56 @@
+package towers
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWalkPuzzlesContinuesPastUnreadableDir(t *testing.T) {
+	puzzle, err := os.ReadFile("problem1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b/x.txt", "c.txt"} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, puzzle, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Removing b once the walk has listed dir makes reading b fail, which
+	// works even for root, unlike taking away its permissions.
+	var visited []string
+	var dirErr error
+	err = walkPuzzles(dir, func(p string, b *Board, err error) {
+		rel, _ := filepath.Rel(dir, p)
+		visited = append(visited, rel)
+		if rel == "a.txt" {
+			if err := os.RemoveAll(filepath.Join(dir, "b")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if rel == "b" {
+			dirErr = err
+		}
+	})
+	if err != nil {
+		t.Fatalf("walkPuzzles returned %v", err)
+	}
+	if want := []string{"a.txt", "b", "c.txt"}; !reflect.DeepEqual(visited, want) {
+		t.Errorf("visited %v, want %v", visited, want)
+	}
+	if !errors.Is(dirErr, fs.ErrNotExist) {
+		t.Errorf("b was reported with error %v, want it not to exist", dirErr)
+	}
+
+	if _, err := SolveDir(filepath.Join(dir, "missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("SolveDir on a missing directory returned %v", err)
+	}
+}
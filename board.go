@@ -1,9 +1,25 @@
-package main
+// Package towers solves towers puzzles, also known as skyscrapers: fill an
+// NxN grid with 1 to N so that every row and column holds each number once and
+// each edge clue counts the towers visible from that edge. Solve turns a
+// puzzle in text form straight into its solution. For more control, parse a
+// puzzle with BoardFromString, solve it with the AutoSolve or Solve method and
+// check it with Solved.
+// The permutation helpers the solver is built on, such as Permute and
+// PermuteN, are exported too. The towers command in cmd/towers is a command
+// line front end.
+package towers
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 var (
@@ -45,39 +61,141 @@ type Observer struct {
 // Perms contains a slice of slices representing all permutations of the
 // numbers 1 to BoardSize, inclusive. RowPerms and ColPerms contain, for each
 // row or column, a slice of indices into Perms representing the permutations
-// that are possible for that row or column.
+// that are possible for that row or column. NoPerms stops InitPerms from
+// generating Perms, leaving the solver to work without them; see
+// SolveLowMemory.
+//
+// Given is true for each cell whose value was supplied by the puzzle rather
+// than placed while solving.
+//
+// History records each Mark in order so that it can be reversed by Undo, and
+// Undone holds the records reversed by Undo so that Redo can replay them.
+//
+// Reasons is nil unless EnableReasonTracking has been called, after which it
+// records why each candidate was removed by the trims.
+//
+// Heuristics lists the techniques AutoSolve runs each round, in order. If it is
+// nil, DefaultHeuristics is used, or LowMemoryHeuristics if the board has no
+// Perms.
+//
+// MaxSetSize, if nonzero, limits the naked set and found group searches of
+// those default lists to sets of at most that many cells. Large sets rarely
+// turn up and are the most expensive to look for on big boards.
+//
+// GuessOrder sets the order in which the backtracking search tries the
+// candidates of each cell it branches on; see the GuessOrder type.
+//
+// Verbose makes AutoSolve log each round and each heuristic that fires to
+// stdout. It is off by default, so solving has no output.
+//
+// Progress, if non-nil, is called by AutoSolve at the end of each solving
+// round with the round number (starting at 1) and the current NumEmpty.
+//
+// HeuristicTimeout, if positive, bounds each heuristic run by AutoSolve. The
+// expensive heuristics check the time in their outer loops and return early
+// with whatever they have removed so far once it runs out; deadline holds the
+// cutoff for the heuristic currently running.
+//
+// trail and frames hold the changes recorded since each unmatched Push, for
+// Pop to reverse; see history.go.
+//
+// mu is the lock taken by the Safe* methods; see safe.go.
 type Board struct {
-	Grid      [][]int
-	Allowed   [][]map[int]interface{}
-	NumEmpty  int
-	Size      int
-	Observers []*Observer
-	ObsSorted []*Observer
-	Perms     [][]int
-	RowPerms  []*[]int
-	ColPerms  []*[]int
+	Grid             [][]int
+	Given            [][]bool
+	Allowed          [][]Set[int]
+	NumEmpty         int
+	Size             int
+	Observers        []*Observer
+	ObsSorted        []*Observer
+	Perms            [][]int
+	RowPerms         []*[]int
+	ColPerms         []*[]int
+	permIndex        []linePermIndex
+	NoPerms          bool
+	History          []*MarkRecord
+	Undone           []*MarkRecord
+	Heuristics       []Heuristic
+	MaxSetSize       int
+	GuessOrder       GuessOrder
+	Verbose          bool
+	Progress         func(round int, numEmpty int)
+	HeuristicTimeout time.Duration
+	deadline         time.Time
+	Reasons          map[CellKey][]Elimination
+	reason           Elimination
+	trail            []trailEntry
+	frames           []pushFrame
+	mu               *sync.RWMutex
 }
 
+// permPrefixLen is how many leading cells PermsForObs checks against the
+// clues before falling back to testing whole permutations from b.Perms.
+const permPrefixLen = 4
+
 // PermsForObs generates a slice of the permutation indexes that fit both
 // observers. If both are nil, returns nil. Must be called after b.Perms has
-// been initialized.
+// been initialized. Since b.Perms is in lexicographic order, the permutations
+// sharing a prefix form a contiguous block, so blocks whose first
+// permPrefixLen cells already rule out the clues are skipped without testing
+// any of their permutations. If Perms has been compacted, only the
+// permutations it still holds are tested.
 func (b *Board) PermsForObs(fwd, bwd *Observer) *[]int {
 	if fwd == nil && bwd == nil {
 		return nil
 	}
 	out := make([]int, 0)
-	for i := 0; i < len(b.Perms); i++ {
-		if PermFitsObs(b.Perms[i], fwd, bwd) {
-			out = append(out, i)
+	if b.permsCompacted() {
+		// The lexicographic blocks are gone, so test what is left.
+		for i, p := range b.Perms {
+			if PermFitsObs(p, fwd, bwd) {
+				out = append(out, i)
+			}
 		}
+		return &out
 	}
+	block := fact(b.Size - permPrefixLen)
+	permuteFitting(b.Size, permPrefixLen, fwd, bwd, func(idx int, p []int) bool {
+		if len(p) == b.Size {
+			out = append(out, idx)
+			return true
+		}
+		for i := idx; i < idx+block; i++ {
+			if PermFitsObs(b.Perms[i], fwd, bwd) {
+				out = append(out, i)
+			}
+		}
+		return true
+	})
 	return &out
 }
 
+// StreamPermsForObs returns the permutations of 1 to b.Size that fit both
+// observers, generating candidates one at a time rather than reading them
+// from b.Perms. This allows large boards to find the permutations for a line
+// without ever holding all b.Size! permutations in memory. If both observers
+// are nil, returns nil.
+func (b *Board) StreamPermsForObs(fwd, bwd *Observer) [][]int {
+	if fwd == nil && bwd == nil {
+		return nil
+	}
+	out := make([][]int, 0)
+	permuteFitting(b.Size, b.Size, fwd, bwd, func(_ int, p []int) bool {
+		tmp := make([]int, len(p))
+		copy(tmp, p)
+		out = append(out, tmp)
+		return true
+	})
+	return out
+}
+
 // PermFitsObs checks whether a given row or column is consistent with both
 // observers. Nil inputs are ignored, so PermFitsObs(_, nil, nil) always
-// returns true.
+// returns true. Calls are counted if ProfilePermFitsObs is set.
 func PermFitsObs(p []int, fwd, bwd *Observer) bool {
+	if ProfilePermFitsObs {
+		permFitsObsCalls.Add(1)
+	}
 	if fwd != nil {
 		vis := 0
 		highest := 0
@@ -113,18 +231,210 @@ func PermFitsObs(p []int, fwd, bwd *Observer) bool {
 	return true
 }
 
+// PermFitsObsPartial checks whether a partially filled line, with EMPTY for
+// unknown cells, could still satisfy both observers once the blanks are
+// filled with the missing values. Rather than an exact visible count, it
+// computes a range of counts for each direction that contains every
+// achievable count, so it never rejects a line that could be completed. For a
+// fully filled line, the range is exact and the result matches PermFitsObs.
+// Nil observers are ignored.
+func PermFitsObsPartial(p []int, fwd, bwd *Observer) bool {
+	if fwd != nil {
+		lo, hi := visibleRange(p, false)
+		if fwd.Count < lo || fwd.Count > hi {
+			return false
+		}
+	}
+	if bwd != nil {
+		lo, hi := visibleRange(p, true)
+		if bwd.Count < lo || bwd.Count > hi {
+			return false
+		}
+	}
+	return true
+}
+
+// visibleRange returns lower and upper bounds on the number of towers visible
+// along the partial line p, viewed from the end if reverse is true. A filled
+// tower is certainly visible if it is taller than everything that is or could
+// be in front of it, and possibly visible if it is taller than every filled
+// tower in front of it. Any blank in front of the tallest tower could be
+// visible, and if the tallest tower is missing, one of the blanks must hold it.
+func visibleRange(p []int, reverse bool) (lo, hi int) {
+	n := len(p)
+	present := make([]bool, n+1)
+	for _, v := range p {
+		if v != EMPTY {
+			present[v] = true
+		}
+	}
+	maxMissing := 0
+	for v := n; v >= 1; v-- {
+		if !present[v] {
+			maxMissing = v
+			break
+		}
+	}
+	highest := 0
+	blanks := false
+	for i := 0; i < n; i++ {
+		idx := i
+		if reverse {
+			idx = n - 1 - i
+		}
+		v := p[idx]
+		if v == EMPTY {
+			blanks = true
+			hi++
+			continue
+		}
+		if v > highest {
+			highest = v
+			hi++
+			if !blanks || v > maxMissing {
+				lo++
+			}
+		}
+		if v == n {
+			break
+		}
+	}
+	if maxMissing == n {
+		lo++
+	}
+	return lo, hi
+}
+
+// clueKey identifies a line's pair of clue counts, with 0 standing for a
+// missing observer. Lines with the same clueKey have the same permutations.
+type clueKey struct {
+	Fwd int
+	Bwd int
+}
+
+// lineClueKey returns the clueKey for line number line, where lines 0 to
+// Size-1 are rows and Size to 2*Size-1 are columns.
+func (b *Board) lineClueKey(line int) clueKey {
+	k := clueKey{}
+	fwd, bwd := b.lineObservers(line)
+	if fwd != nil {
+		k.Fwd = fwd.Count
+	}
+	if bwd != nil {
+		k.Bwd = bwd.Count
+	}
+	return k
+}
+
+// setLinePerms stores a copy of perms as the permutation list for line number
+// line. A nil perms leaves the line unconstrained. Each line gets its own copy
+// so that lines sharing a clue pair never share a backing array.
+func (b *Board) setLinePerms(line int, perms *[]int) {
+	if perms != nil {
+		tmp := make([]int, len(*perms))
+		copy(tmp, *perms)
+		perms = &tmp
+	}
+	b.swapLinePerms(line, perms)
+}
+
+// swapLinePerms replaces the permutation list for line number line with perms
+// itself, recording the old list for Pop.
+func (b *Board) swapLinePerms(line int, perms *[]int) {
+	if line < b.Size {
+		b.record(trailEntry{kind: trailPerms, row: line, perms: b.RowPerms[line]})
+		b.RowPerms[line] = perms
+		return
+	}
+	b.record(trailEntry{kind: trailPerms, row: line, perms: b.ColPerms[line-b.Size]})
+	b.ColPerms[line-b.Size] = perms
+}
+
+// LinePerms returns the permutations still feasible for row or column index,
+// depending on typ, as the sequences of values themselves rather than indexes
+// into Perms. Each one is a copy, so callers can't disturb the shared Perms.
+// Returns nil if the line has no permutation list, either because Perms
+// hasn't been generated or because the line has no clues.
+func (b *Board) LinePerms(typ, index int) [][]int {
+	line := index
+	if typ == OBS_COL {
+		line += b.Size
+	}
+	perms := b.linePermsFor(line)
+	if perms == nil {
+		return nil
+	}
+	out := make([][]int, len(*perms))
+	for i, pi := range *perms {
+		out[i] = append([]int(nil), b.Perms[pi]...)
+	}
+	return out
+}
+
 // PopulateRowColPerms is used during initialization to generate the lists of
-// allowed permutations for each row and column.
+// allowed permutations for each row and column. PermsForObs is only called
+// once for each distinct pair of clues; lines with matching clues get copies
+// of the same result. A compacted Perms is expanded first.
 func (b *Board) PopulateRowColPerms() {
-	pi := 0
-	for ri := 0; ri < b.Size; ri++ {
-		b.RowPerms[ri] = b.PermsForObs(b.ObsSorted[pi], b.ObsSorted[pi+1])
-		pi += 2
+	b.expandPerms()
+	memo := make(map[clueKey]*[]int)
+	for line := 0; line < b.Size*2; line++ {
+		k := b.lineClueKey(line)
+		perms, ok := memo[k]
+		if !ok {
+			perms = b.PermsForObs(b.lineObservers(line))
+			memo[k] = perms
+		}
+		b.setLinePerms(line, perms)
 	}
-	for ci := 0; ci < b.Size; ci++ {
-		b.ColPerms[ci] = b.PermsForObs(b.ObsSorted[pi], b.ObsSorted[pi+1])
-		pi += 2
+}
+
+// PopulateRowColPermsParallel does the same work as PopulateRowColPerms but
+// spreads the PermsForObs calls for each distinct clue pair across
+// runtime.NumCPU() goroutines. Each worker only reads b.Perms and b.ObsSorted
+// and writes to its own slot in the results, so the results are identical to
+// the sequential version.
+func (b *Board) PopulateRowColPermsParallel() {
+	b.expandPerms()
+	keys := make([]clueKey, 0)
+	first := make(map[clueKey]int)
+	for line := 0; line < b.Size*2; line++ {
+		k := b.lineClueKey(line)
+		if _, ok := first[k]; !ok {
+			first[k] = line
+			keys = append(keys, k)
+		}
+	}
+	results := make([]*[]int, len(keys))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results[j] = b.PermsForObs(b.lineObservers(first[keys[j]]))
+			}
+		}()
+	}
+	for j := range keys {
+		jobs <- j
 	}
+	close(jobs)
+	wg.Wait()
+	memo := make(map[clueKey]*[]int)
+	for j, k := range keys {
+		memo[k] = results[j]
+	}
+	for line := 0; line < b.Size*2; line++ {
+		b.setLinePerms(line, memo[b.lineClueKey(line)])
+	}
+}
+
+// timeUp returns true iff the heuristic currently being run by AutoSolve has
+// used up its HeuristicTimeout.
+func (b *Board) timeUp() bool {
+	return !b.deadline.IsZero() && time.Now().After(b.deadline)
 }
 
 // Get returns the grid value at the specified coordinates.
@@ -132,16 +442,9 @@ func (b *Board) Get(ri, ci int) int {
 	return b.Grid[ri][ci]
 }
 
-// ObserverSatisfied returns false if the grid's contents are consistent with
-// the constraint for the specified observer. This function will treat empty
-// cells as a zero (meaning that such cells are never visible and never
-// obstruct other cells), so the return value may be misleading if called when
-// the relevant row or column is incomplete.
-func (b *Board) ObserverSatisfied(o *Observer) bool {
-	ri := 0
-	ci := 0
-	dr := 0
-	dc := 0
+// ObserverPath returns the coordinates of the cell nearest to the observer
+// and the row and column deltas that step away from it along its line.
+func (b *Board) ObserverPath(o *Observer) (ri, ci, dr, dc int) {
 	if o.Type == OBS_ROW {
 		ri = o.Index
 		dc = 1
@@ -157,6 +460,15 @@ func (b *Board) ObserverSatisfied(o *Observer) bool {
 			dr = -1
 		}
 	}
+	return
+}
+
+// VisibleCount returns the number of towers currently visible from the
+// direction given along the row or column specified by typ and index. Empty
+// cells count as zero, so they are never visible and never hide the towers
+// behind them.
+func (b *Board) VisibleCount(typ, index, direction int) int {
+	ri, ci, dr, dc := b.ObserverPath(&Observer{Type: typ, Index: index, Direction: direction})
 	vis := 0
 	highest := 0
 	for i := 0; i < b.Size; i++ {
@@ -168,43 +480,287 @@ func (b *Board) ObserverSatisfied(o *Observer) bool {
 		ri += dr
 		ci += dc
 	}
-	return vis == o.Count
+	return vis
+}
+
+// ObserverSatisfied returns true if the grid's contents are consistent with
+// the constraint for the specified observer. Empty cells are treated as in
+// VisibleCount, so the return value may be misleading if called when the
+// relevant row or column is incomplete.
+func (b *Board) ObserverSatisfied(o *Observer) bool {
+	return b.VisibleCount(o.Type, o.Index, o.Direction) == o.Count
 }
 
 // AddObserver seeds the Observer object into Observers and into ObsSorted at
-// the correct index.
-func (b *Board) AddObserver(o *Observer) {
+// the correct index. An observer with a Count of 0 is skipped. Returns an
+// error, leaving the board untouched, if the observer's type, direction,
+// index or count is out of range, or if the slot is already taken by another
+// observer.
+func (b *Board) AddObserver(o *Observer) error {
 	if o.Count == 0 {
-		return
+		return nil
 	}
-	b.Observers = append(b.Observers, o)
-	ind := 0
-	if o.Type == OBS_ROW {
-		ind = o.Index * 2
-	} else {
-		ind = b.Size*2 + (o.Index * 2)
+	if err := b.checkObserverSlot(o.Type, o.Index, o.Direction); err != nil {
+		return err
 	}
-	if o.Direction == OBS_BWD {
-		ind += 1
+	if o.Count < 0 || o.Count > b.Size {
+		return fmt.Errorf("observer count %d is out of range for board size %d", o.Count, b.Size)
 	}
+	ind := b.obsIndex(o.Type, o.Index, o.Direction)
 	if b.ObsSorted[ind] != nil {
-		panic(fmt.Sprintf("AddObserver is replacing observer %s with new observer %s", b.ObsSorted[ind], o))
+		return fmt.Errorf("observer %s would replace observer %s", o, b.ObsSorted[ind])
 	}
+	b.Observers = append(b.Observers, o)
 	b.ObsSorted[ind] = o
+	return nil
+}
+
+// obsIndex returns the position in ObsSorted of the observer of type typ for
+// line index looking in the given direction.
+func (b *Board) obsIndex(typ, index, direction int) int {
+	ind := index * 2
+	if typ == OBS_COL {
+		ind += b.Size * 2
+	}
+	if direction == OBS_BWD {
+		ind++
+	}
+	return ind
+}
+
+// ObserversFor returns the forward and backward observers for row or column
+// index, depending on typ. Either may be nil if that end of the line has no
+// clue.
+func (b *Board) ObserversFor(typ, index int) (fwd, bwd *Observer) {
+	i := b.obsIndex(typ, index, OBS_FWD)
+	return b.ObsSorted[i], b.ObsSorted[i+1]
+}
+
+// CluePairPlausible reports whether the two clues on row or column index,
+// depending on typ, could both be met by some line, without looking at the
+// rest of the board. The tallest tower is seen from both ends, so the counts
+// add up to at least 3, or exactly 2 on a board of size 1, and no other tower
+// is seen from both ends, so they add up to at most Size+1. Every pair within
+// those bounds can be met. Lines with one clue or none always return true.
+// This is meant as a cheap filter to run before a uniqueness check.
+func (b *Board) CluePairPlausible(typ, index int) bool {
+	fwd, bwd := b.ObserversFor(typ, index)
+	if fwd == nil || bwd == nil {
+		return true
+	}
+	return checkCluePair(b.Size, fwd, bwd) == nil
+}
+
+// checkCluePair returns an error saying why no line of length size meets both
+// fwd and bwd, or nil if some line does. The bounds are the ones described at
+// CluePairPlausible; on a board of size 1 the upper bound alone forces the
+// sum to 2.
+func checkCluePair(size int, fwd, bwd *Observer) error {
+	if fwd.Count < 1 || bwd.Count < 1 {
+		return fmt.Errorf("observers %s and %s: counts must be at least 1", fwd, bwd)
+	}
+	sum := fwd.Count + bwd.Count
+	if sum > size+1 {
+		return fmt.Errorf("observers %s and %s: counts add up to %d, more than %d", fwd, bwd, sum, size+1)
+	}
+	if size > 1 && sum < 3 {
+		return fmt.Errorf("observers %s and %s: tallest tower can't be at both ends", fwd, bwd)
+	}
+	return nil
+}
+
+// lineObservers is ObserversFor for line number line, where lines 0 to Size-1
+// are rows and Size to 2*Size-1 are columns.
+func (b *Board) lineObservers(line int) (fwd, bwd *Observer) {
+	if line < b.Size {
+		return b.ObserversFor(OBS_ROW, line)
+	}
+	return b.ObserversFor(OBS_COL, line-b.Size)
+}
+
+// SetEdgeClue creates an observer of type typ for line index looking in the
+// given direction and adds it with AddObserver. A count of 0 is skipped, just
+// as it is by AddObserver. If the board's permutations have already been
+// generated, the line's permutation list is recomputed to match the new clue.
+// Returns the error from AddObserver if the clue is invalid.
+func (b *Board) SetEdgeClue(typ, index, direction, count int) error {
+	err := b.AddObserver(&Observer{
+		Type:      typ,
+		Index:     index,
+		Direction: direction,
+		Count:     count,
+	})
+	if err != nil {
+		return err
+	}
+	b.refreshLinePerms(typ, index)
+	return nil
 }
 
+// RemoveObserver removes the observer of type typ for line index looking in
+// the given direction, clearing its ObsSorted slot and dropping it from
+// Observers. Removing a clue that isn't there does nothing. If the board's
+// permutations have already been generated, the line's permutation list is
+// recomputed without the clue. Candidates that were removed because of the
+// clue are not restored; call RebuildAllowed for that. Returns an error if
+// typ, index or direction is out of range.
+func (b *Board) RemoveObserver(typ, index, direction int) error {
+	if err := b.checkObserverSlot(typ, index, direction); err != nil {
+		return err
+	}
+	ind := b.obsIndex(typ, index, direction)
+	o := b.ObsSorted[ind]
+	if o == nil {
+		return nil
+	}
+	b.ObsSorted[ind] = nil
+	for i, other := range b.Observers {
+		if other == o {
+			b.Observers = append(b.Observers[:i:i], b.Observers[i+1:]...)
+			break
+		}
+	}
+	b.refreshLinePerms(typ, index)
+	return nil
+}
+
+// SetObserverCount changes the clue of type typ for line index looking in the
+// given direction to count, adding the observer if there was none and
+// removing it if count is 0. The old Observer is replaced rather than edited,
+// since clones of the board share it. As with RemoveObserver, the line's
+// permutation list is recomputed but removed candidates are not restored.
+// Returns an error, leaving the board untouched, if the clue is invalid.
+func (b *Board) SetObserverCount(typ, index, direction, count int) error {
+	if err := b.checkObserverSlot(typ, index, direction); err != nil {
+		return err
+	}
+	if count < 0 || count > b.Size {
+		return fmt.Errorf("observer count %d is out of range for board size %d", count, b.Size)
+	}
+	if count == 0 {
+		return b.RemoveObserver(typ, index, direction)
+	}
+	ind := b.obsIndex(typ, index, direction)
+	o := &Observer{Type: typ, Index: index, Direction: direction, Count: count}
+	if old := b.ObsSorted[ind]; old != nil {
+		for i, other := range b.Observers {
+			if other == old {
+				b.Observers = append([]*Observer(nil), b.Observers...)
+				b.Observers[i] = o
+				break
+			}
+		}
+		b.ObsSorted[ind] = o
+		b.refreshLinePerms(typ, index)
+		return nil
+	}
+	return b.SetEdgeClue(typ, index, direction, count)
+}
+
+// checkObserverSlot returns an error if typ, index or direction doesn't name
+// one of the board's clue positions.
+func (b *Board) checkObserverSlot(typ, index, direction int) error {
+	if typ != OBS_ROW && typ != OBS_COL {
+		return fmt.Errorf("observer has invalid type %d", typ)
+	}
+	if direction != OBS_FWD && direction != OBS_BWD {
+		return fmt.Errorf("observer has invalid direction %d", direction)
+	}
+	if index < 0 || index >= b.Size {
+		return fmt.Errorf("observer index %d is out of range for board size %d", index, b.Size)
+	}
+	return nil
+}
+
+// refreshLinePerms recomputes the permutation list for row or column index,
+// depending on typ, from its current observers. Does nothing if the board's
+// permutations haven't been generated.
+func (b *Board) refreshLinePerms(typ, index int) {
+	if b.Perms == nil {
+		return
+	}
+	b.expandPerms()
+	line := index
+	if typ == OBS_COL {
+		line += b.Size
+	}
+	b.setLinePerms(line, b.PermsForObs(b.lineObservers(line)))
+}
+
+// SetCell marks val at row ri, col ci as a given, as if it had been part of
+// the parsed puzzle, or clears the cell if val is EMPTY. Returns true iff the
+// cell was changed, or an error if the cell or value is out of range.
+// Clearing or overwriting a filled cell can't be done with Mark, since the
+// candidates its old value removed have to come back, so the candidate state
+// is rebuilt with RebuildAllowed instead, which also clears History.
+func (b *Board) SetCell(ri, ci, val int) (bool, error) {
+	if ri < 0 || ri >= b.Size || ci < 0 || ci >= b.Size {
+		return false, fmt.Errorf("cell (%d, %d) is off the %dx%d board", ri, ci, b.Size, b.Size)
+	}
+	if val < EMPTY || val > b.Size {
+		return false, fmt.Errorf("cell (%d, %d): value %d is out of range 0 to %d", ri, ci, val, b.Size)
+	}
+	changed := false
+	if old := b.Get(ri, ci); old != EMPTY && old != val {
+		b.Set(ri, ci, EMPTY)
+		b.RebuildAllowed()
+		changed = true
+	}
+	if val != EMPTY {
+		marked, _ := b.Mark(ri, ci, val)
+		changed = changed || marked
+	}
+	b.Given[ri][ci] = val != EMPTY
+	return changed, nil
+}
+
+// String formats the observer as its type, index, direction and count, e.g.
+// "row 3 FWD sees 2" or "col 0 BWD sees 4". ParseObserver reads the same
+// format back.
 func (o Observer) String() string {
-	out := ""
-	if o.Type == OBS_ROW {
-		out += fmt.Sprintf("row %d", o.Index)
-	} else {
-		out += fmt.Sprintf("col %d", o.Index)
+	typ := "row"
+	if o.Type == OBS_COL {
+		typ = "col"
 	}
+	dir := "FWD"
 	if o.Direction == OBS_BWD {
-		out += " BWD"
+		dir = "BWD"
 	}
-	out += fmt.Sprintf(" sees %d", o.Count)
-	return out
+	return fmt.Sprintf("%s %d %s sees %d", typ, o.Index, dir, o.Count)
+}
+
+// ParseObserver parses an observer in the format produced by String. Only the
+// format is checked; AddObserver checks the index and count against a board.
+func ParseObserver(s string) (*Observer, error) {
+	f := strings.Fields(s)
+	if len(f) != 5 || f[3] != "sees" {
+		return nil, fmt.Errorf("observer %q is not of the form \"row 3 FWD sees 2\"", s)
+	}
+	o := &Observer{}
+	switch f[0] {
+	case "row":
+		o.Type = OBS_ROW
+	case "col":
+		o.Type = OBS_COL
+	default:
+		return nil, fmt.Errorf("observer %q has type %q; want row or col", s, f[0])
+	}
+	switch f[2] {
+	case "FWD":
+		o.Direction = OBS_FWD
+	case "BWD":
+		o.Direction = OBS_BWD
+	default:
+		return nil, fmt.Errorf("observer %q has direction %q; want FWD or BWD", s, f[2])
+	}
+	var err error
+	if o.Index, err = strconv.Atoi(f[1]); err != nil {
+		return nil, fmt.Errorf("observer %q has a bad index: %w", s, err)
+	}
+	if o.Count, err = strconv.Atoi(f[4]); err != nil {
+		return nil, fmt.Errorf("observer %q has a bad count: %w", s, err)
+	}
+	return o, nil
 }
 
 // Solved returns true iff all observers are satisfied and all cells are
@@ -221,61 +777,446 @@ func (b *Board) Solved() error {
 	return nil
 }
 
+// CheckConsistency checks the invariants that tie Grid, NumEmpty and Allowed
+// together: NumEmpty counts the empty cells, a filled cell allows nothing but
+// its own value, and no empty cell in the same row or column still allows
+// that value. Unlike Solved, it holds for a partially solved board too, so it
+// can be used to check the state a canceled solve left behind. Returns an
+// error describing the first violation found.
+func (b *Board) CheckConsistency() error {
+	return b.checkCells(true)
+}
+
+// checkCells does the work of CheckConsistency. The check on the empty cells
+// around each filled one is only made if peers is set, since a bare Set
+// doesn't remove the value from them the way Mark does.
+func (b *Board) checkCells(peers bool) error {
+	empty := 0
+	for ri := 0; ri < b.Size; ri++ {
+		for ci := 0; ci < b.Size; ci++ {
+			v := b.Get(ri, ci)
+			if v == EMPTY {
+				empty++
+				continue
+			}
+			for _, n := range b.Candidates(ri, ci) {
+				if n != v {
+					return fmt.Errorf("cell (%d, %d) holds %d but still allows %d", ri, ci, v, n)
+				}
+			}
+			if !peers {
+				continue
+			}
+			for i := 0; i < b.Size; i++ {
+				if i != ri && b.Get(i, ci) == EMPTY && b.IsAllowed(i, ci, v) {
+					return fmt.Errorf("cell (%d, %d) allows %d, which is placed at (%d, %d)", i, ci, v, ri, ci)
+				}
+				if i != ci && b.Get(ri, i) == EMPTY && b.IsAllowed(ri, i, v) {
+					return fmt.Errorf("cell (%d, %d) allows %d, which is placed at (%d, %d)", ri, i, v, ri, ci)
+				}
+			}
+		}
+	}
+	if empty != b.NumEmpty {
+		return fmt.Errorf("NumEmpty is %d but %d cells are empty", b.NumEmpty, empty)
+	}
+	return nil
+}
+
+// ValidateObserverPairs checks the clues on each line for arithmetic
+// impossibilities without generating any permutations. Each count must be
+// between 1 and Size. The tallest tower is visible from both ends, and the
+// towers visible from each side are otherwise distinct, so a line's forward
+// and backward counts can add up to at most Size + 1. Unless Size is 1, the
+// tallest tower can't be at both ends, so the counts must add up to at least
+// 3. Lines with fewer than two clues only have their counts range-checked.
+func (b *Board) ValidateObserverPairs() error {
+	for _, o := range b.Observers {
+		if o.Count < 1 || o.Count > b.Size {
+			return fmt.Errorf("observer %s: count must be between 1 and %d", o, b.Size)
+		}
+	}
+	for i := 0; i < b.Size*2; i++ {
+		fwd, bwd := b.lineObservers(i)
+		if fwd == nil || bwd == nil {
+			continue
+		}
+		if err := checkCluePair(b.Size, fwd, bwd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Equals returns true iff other has the same size, grid and observer clues as
+// b. Candidate state, permutation lists and history are ignored, as is the
+// order in which observers were added.
+func (b *Board) Equals(other *Board) bool {
+	if other == nil || b.Size != other.Size {
+		return false
+	}
+	for ri := 0; ri < b.Size; ri++ {
+		for ci := 0; ci < b.Size; ci++ {
+			if b.Get(ri, ci) != other.Get(ri, ci) {
+				return false
+			}
+		}
+	}
+	for i, o := range b.ObsSorted {
+		p := other.ObsSorted[i]
+		if (o == nil) != (p == nil) {
+			return false
+		}
+		if o != nil && o.Count != p.Count {
+			return false
+		}
+	}
+	return true
+}
+
+// EqualsStrict returns true iff b.Equals(other) and every cell has the same
+// set of allowed values in both boards.
+func (b *Board) EqualsStrict(other *Board) bool {
+	if !b.Equals(other) {
+		return false
+	}
+	for ri := 0; ri < b.Size; ri++ {
+		for ci := 0; ci < b.Size; ci++ {
+			if !b.Allowed[ri][ci].Equal(other.Allowed[ri][ci]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// PartialValid checks a possibly incomplete grid for violations that can
+// already be proven. Each row and column must not contain the same nonzero
+// value twice, and for each observer, the towers in the filled cells nearest
+// to the observer (up to the first empty cell) must not already be more than
+// its count. If the tallest tower is among those cells, the count is final and
+// must match exactly. Returns the first violation found, or nil.
+func (b *Board) PartialValid() error {
+	if errs := b.latinViolations(); len(errs) > 0 {
+		return errs[0]
+	}
+	for _, o := range b.Observers {
+		if err := b.observerPartialError(o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// latinViolations returns an error for every value that appears more than
+// once in a row or column, comparing each repeat with the first occurrence.
+func (b *Board) latinViolations() []error {
+	errs := make([]error, 0)
+	for i := 0; i < b.Size; i++ {
+		rowSeen := make(map[int]int)
+		colSeen := make(map[int]int)
+		for j := 0; j < b.Size; j++ {
+			if v := b.Get(i, j); v != EMPTY {
+				if prev, ok := rowSeen[v]; ok {
+					errs = append(errs, fmt.Errorf("row %d has %d at cols %d and %d", i, v, prev, j))
+				} else {
+					rowSeen[v] = j
+				}
+			}
+			if v := b.Get(j, i); v != EMPTY {
+				if prev, ok := colSeen[v]; ok {
+					errs = append(errs, fmt.Errorf("col %d has %d at rows %d and %d", i, v, prev, j))
+				} else {
+					colSeen[v] = j
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// observerPartialError applies the PartialValid check for observer o to the
+// filled cells nearest it, returning the violation found or nil.
+func (b *Board) observerPartialError(o *Observer) error {
+	ri, ci, dr, dc := b.ObserverPath(o)
+	vis := 0
+	highest := 0
+	for i := 0; i < b.Size; i++ {
+		val := b.Get(ri, ci)
+		if val == EMPTY {
+			break
+		}
+		if val > highest {
+			vis++
+			highest = val
+		}
+		if vis > o.Count {
+			return fmt.Errorf("cell (%d, %d) makes %d visible for observer %s", ri, ci, vis, o)
+		}
+		if val == b.Size && vis != o.Count {
+			return fmt.Errorf("cell (%d, %d) hides the rest of the line with %d visible for observer %s", ri, ci, vis, o)
+		}
+		ri += dr
+		ci += dc
+	}
+	return nil
+}
+
+// VerifyAll returns every problem with the board rather than just the first:
+// the number of empty cells, if any, each repeated value in a row or column,
+// and each observer whose count is not met. Observers of complete lines are
+// checked exactly, as in Solved; those of incomplete lines get the partial
+// check of PartialValid. An empty result means the board is solved and valid.
+func (b *Board) VerifyAll() []error {
+	errs := make([]error, 0)
+	if b.NumEmpty != 0 {
+		errs = append(errs, fmt.Errorf("grid has %d empty cells; need 0", b.NumEmpty))
+	}
+	errs = append(errs, b.latinViolations()...)
+	for _, o := range b.Observers {
+		if b.lineComplete(o) {
+			if !b.ObserverSatisfied(o) {
+				errs = append(errs, fmt.Errorf("observer %s unsatisfied", o))
+			}
+		} else if err := b.observerPartialError(o); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// lineComplete returns true iff every cell in the line watched by o is
+// filled.
+func (b *Board) lineComplete(o *Observer) bool {
+	ri, ci, dr, dc := b.ObserverPath(o)
+	for i := 0; i < b.Size; i++ {
+		if b.Get(ri+dr*i, ci+dc*i) == EMPTY {
+			return false
+		}
+	}
+	return true
+}
+
+// A ClueState says how an observer's clue compares with the grid so far.
+type ClueState int
+
+const (
+	// ClueIncomplete means the line has blanks that could still go either
+	// way.
+	ClueIncomplete ClueState = iota
+	// ClueSatisfied means the observer sees exactly its count and nothing
+	// left to fill can change that.
+	ClueSatisfied
+	// ClueOver means the observer already sees more towers than its count.
+	ClueOver
+	// ClueUnder means the observer can no longer see as many towers as its
+	// count.
+	ClueUnder
+)
+
+func (s ClueState) String() string {
+	switch s {
+	case ClueSatisfied:
+		return "satisfied"
+	case ClueOver:
+		return "over"
+	case ClueUnder:
+		return "under"
+	}
+	return "incomplete"
+}
+
+// An ObserverStatus reports the progress of one observer: the number of
+// towers it currently sees, as counted by VisibleCount, and the state of its
+// clue.
+type ObserverStatus struct {
+	Observer *Observer
+	Visible  int
+	State    ClueState
+}
+
+// ObserverStatus returns the status of every observer, in the order of
+// Observers. A line with blanks is ClueIncomplete unless the filled cells
+// nearest the observer already decide the clue: more towers visible than its
+// count, or the tallest tower in place so that nothing behind it is visible.
+func (b *Board) ObserverStatus() []ObserverStatus {
+	out := make([]ObserverStatus, 0, len(b.Observers))
+	for _, o := range b.Observers {
+		st := ObserverStatus{
+			Observer: o,
+			Visible:  b.VisibleCount(o.Type, o.Index, o.Direction),
+		}
+		ri, ci, dr, dc := b.ObserverPath(o)
+		vis, highest := 0, 0
+		for i := 0; i < b.Size && highest < b.Size; i++ {
+			val := b.Get(ri+dr*i, ci+dc*i)
+			if val == EMPTY {
+				break
+			}
+			if val > highest {
+				vis++
+				highest = val
+			}
+		}
+		final := highest == b.Size || b.lineComplete(o)
+		switch {
+		case vis > o.Count:
+			st.State = ClueOver
+		case !final:
+			st.State = ClueIncomplete
+		case vis < o.Count:
+			st.State = ClueUnder
+		default:
+			st.State = ClueSatisfied
+		}
+		out = append(out, st)
+	}
+	return out
+}
+
 // Mark sets cell at row ri, col ci as val. Return values are:
 //   - true iff the cell was changed
 //   - true iff a neighbor of the updated cell had val removed from its
 //     allowed list
+//
+// Every change Mark makes is recorded in the board's history so that it can
+// be reversed with Undo.
 func (b *Board) Mark(ri, ci, val int) (bool, bool) {
 	neighborUpdated := false
+	old := b.Get(ri, ci)
 	if !b.Set(ri, ci, val) {
 		return false, false
 	}
+	rec := &MarkRecord{Row: ri, Col: ci, Old: old, New: val}
 	for i := 0; i < b.Size; i++ {
 		if i != ri && b.IsAllowed(i, ci, val) {
-			delete(b.Allowed[i][ci], val)
+			b.disallow(i, ci, val)
+			rec.Removed = append(rec.Removed, Elim{i, ci, val})
 			neighborUpdated = true
 		}
 		if i != ci && b.IsAllowed(ri, i, val) {
-			delete(b.Allowed[ri][i], val)
+			b.disallow(ri, i, val)
+			rec.Removed = append(rec.Removed, Elim{ri, i, val})
 			neighborUpdated = true
 		}
 	}
 	for i := 1; i <= b.Size; i++ {
-		if i != val {
-			delete(b.Allowed[ri][ci], i)
+		if i != val && b.IsAllowed(ri, ci, i) {
+			b.disallow(ri, ci, i)
+			rec.Removed = append(rec.Removed, Elim{ri, ci, i})
 		}
 	}
+	b.History = append(b.History, rec)
+	b.Undone = nil
+	if DebugInvariants {
+		b.checkInvariants()
+	}
 	return true, neighborUpdated
 }
 
 // Unset is a shortcut for Set(ri, ci, EMPTY).
 func (b *Board) Unset(ri, ci int) bool {
-	return b.Set(ri, ci, EMPTY)
+	changed := b.Set(ri, ci, EMPTY)
+	if DebugInvariants {
+		b.checkInvariants()
+	}
+	return changed
 }
 
 // IsAllowed queries the Allowed list for the specified cell, returning a bool.
 func (b *Board) IsAllowed(ri, ci, n int) bool {
-	_, ok := b.Allowed[ri][ci][n]
-	return ok
+	return b.Allowed[ri][ci].Has(n)
+}
+
+// Candidates returns the values still allowed in the specified cell, sorted in
+// ascending order.
+func (b *Board) Candidates(ri, ci int) []int {
+	out := b.Allowed[ri][ci].Keys()
+	sort.Ints(out)
+	return out
+}
+
+// CandidateCount returns the number of values still allowed in the specified
+// cell.
+func (b *Board) CandidateCount(ri, ci int) int {
+	return b.Allowed[ri][ci].Len()
+}
+
+// CompletionPercent returns the percentage of cells that have been filled in,
+// from 0 to 100.
+func (b *Board) CompletionPercent() float64 {
+	total := b.Size * b.Size
+	if total == 0 {
+		return 100
+	}
+	return float64(total-b.NumEmpty) / float64(total) * 100
+}
+
+// EmptyCells returns the row and column of every empty cell, in row-major
+// order.
+func (b *Board) EmptyCells() [][2]int {
+	out := make([][2]int, 0, b.NumEmpty)
+	for ri := 0; ri < b.Size; ri++ {
+		for ci := 0; ci < b.Size; ci++ {
+			if b.Get(ri, ci) == EMPTY {
+				out = append(out, [2]int{ri, ci})
+			}
+		}
+	}
+	return out
 }
 
+// CandidatesString lists the candidates of every empty cell, one cell per
+// line in row-major order, in the form "r,c:v1,v2,...". Values are sorted, so
+// the output is stable and can be diffed or parsed by other tools. Filled cells
+// are omitted.
+func (b *Board) CandidatesString() string {
+	out := ""
+	for _, cell := range b.EmptyCells() {
+		vals := make([]string, 0, b.Size)
+		for _, v := range b.Candidates(cell[0], cell[1]) {
+			vals = append(vals, strconv.Itoa(v))
+		}
+		out += fmt.Sprintf("%d,%d:%s\n", cell[0], cell[1], strings.Join(vals, ","))
+	}
+	return out
+}
+
+// MaxGlyph is the largest number that IntToCh and ChToInt can represent: the
+// digits cover 1-9, lowercase letters cover 10-35 and uppercase letters cover
+// 36-61.
+const MaxGlyph = 61
+
 // IntToCh generates a rune representing a number, starting with digits and
-// continuing through lowercase letters.
+// continuing through lowercase and then uppercase letters. Returns '?' for
+// numbers outside 0 to MaxGlyph.
 func IntToCh(n int) rune {
+	if n < 0 || n > MaxGlyph {
+		return '?'
+	}
+	if n > 35 {
+		return 'A' + rune(n-36)
+	}
 	if n > 9 {
 		return 'a' + rune(n-10)
 	}
 	return '0' + rune(n)
 }
 
-// ChToInt reverses IntToCh, parsing a rune and turning it into an int.
-func ChToInt(ch rune) int {
-	if ch >= '1' && ch <= '9' {
-		return int(ch - '0')
+// ChToInt reverses IntToCh, parsing a rune and turning it into an int. Spaces
+// and '0' are parsed as EMPTY; any other rune outside the encoding returns an
+// error.
+func ChToInt(ch rune) (int, error) {
+	if ch == ' ' || ch == '0' {
+		return EMPTY, nil
+	} else if ch >= '1' && ch <= '9' {
+		return int(ch - '0'), nil
 	} else if ch >= 'a' && ch <= 'z' {
-		return int(ch-'a') + 10
+		return int(ch-'a') + 10, nil
+	} else if ch >= 'A' && ch <= 'Z' {
+		return int(ch-'A') + 36, nil
 	}
-	return 0
+	return 0, fmt.Errorf("invalid character %q", ch)
 }
 
 // BoardFromFile takes a filename as input and generates a board from it.
@@ -287,34 +1228,271 @@ func BoardFromFile(f string) (*Board, error) {
 	return BoardFromString(string(data))
 }
 
-// BoardFromString takes an input string and parses it into a board.
+// MaxBoardSize is the largest board BoardFromString accepts. Bigger boards are
+// slow to solve even without Perms, so they have to be parsed explicitly with
+// BoardFromStringOpts, which goes up to MaxGlyph, and are best parsed with
+// SkipPerms set or solved with SolveLowMemory.
+const MaxBoardSize = 12
+
+// BoardFromString takes an input string and parses it into a board. Empty
+// lines and lines beginning with '#' are ignored, so puzzle files can carry
+// comments. Lines containing only spaces are not ignored, since they are
+// border rows with no clues, unless they come first or last and are too
+// narrow to be border rows. Windows and old Mac line endings are accepted.
+// Either a space or a '.' marks an empty cell or a missing clue. A '0' clue is
+// rejected, since no line can show zero towers. Clues and cell values use the
+// same glyphs as IntToCh, so on boards larger than 9, 'a' is a clue or value
+// of 10, 'b' is 11 and so on; a glyph larger than the board size is rejected.
+// Boards larger than MaxBoardSize are rejected too.
 func BoardFromString(input string) (*Board, error) {
-	b := Board{}
+	b, err := BoardFromStringOpts(input, ParseOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if b.Size > MaxBoardSize {
+		return nil, fmt.Errorf("board size %d exceeds maximum %d; use BoardFromStringOpts and SolveLowMemory", b.Size, MaxBoardSize)
+	}
+	return b, nil
+}
+
+// BoardFromStringWithHints is BoardFromString for puzzles that also narrow
+// some cells to a set of candidates, such as pencil-mark variants. hints maps
+// a {row, col} pair to the values that cell may hold; every other value is
+// removed from it with DisallowOthers before solving starts, and the line
+// permutation lists are trimmed to match so the solver propagates from the
+// restrictions as usual. A hint on a filled cell must include its value. An
+// error is returned for a cell or value off the board, or, wrapping
+// ErrUnsatisfiable, for a hint that leaves a cell with no candidates.
+func BoardFromStringWithHints(puzzle string, hints map[[2]int][]int) (*Board, error) {
+	b, err := BoardFromString(puzzle)
+	if err != nil {
+		return nil, err
+	}
+	cells := make([][2]int, 0, len(hints))
+	for k := range hints {
+		cells = append(cells, k)
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i][0] != cells[j][0] {
+			return cells[i][0] < cells[j][0]
+		}
+		return cells[i][1] < cells[j][1]
+	})
+	for _, k := range cells {
+		ri, ci := k[0], k[1]
+		if ri < 0 || ri >= b.Size || ci < 0 || ci >= b.Size {
+			return nil, fmt.Errorf("hint for cell (%d, %d) is off the %dx%d board", ri, ci, b.Size, b.Size)
+		}
+		vals := hints[k]
+		for _, v := range vals {
+			if v < 1 || v > b.Size {
+				return nil, fmt.Errorf("hint for cell (%d, %d): value %d is out of range 1 to %d", ri, ci, v, b.Size)
+			}
+		}
+		if v := b.Get(ri, ci); v != EMPTY {
+			if !SliceContains(vals, v) {
+				return nil, fmt.Errorf("hint for cell (%d, %d) excludes its value %d: %w", ri, ci, v, ErrUnsatisfiable)
+			}
+			continue
+		}
+		b.DisallowOthers(ri, ci, vals)
+		if b.Allowed[ri][ci].Len() == 0 {
+			return nil, fmt.Errorf("hint for cell (%d, %d) leaves no candidates: %w", ri, ci, ErrUnsatisfiable)
+		}
+	}
+	if b.Perms != nil {
+		b.TrimPermsFromAllowed()
+		b.TrimAllowedFromPerms()
+	}
+	return b, nil
+}
+
+// BoardFromClueLists builds a board from the four clue lists used by most
+// published skyscraper puzzles: the clues along the top and bottom edges, left
+// to right, and along the left and right edges, top to bottom. A 0 means no
+// clue. Each list must have size entries.
+func BoardFromClueLists(size int, top, bottom, left, right []int) (*Board, error) {
+	if size < 1 || size > MaxGlyph {
+		return nil, fmt.Errorf("board size %d is out of range 1 to %d", size, MaxGlyph)
+	}
+	b := NewBoard(size)
+	for _, edge := range []struct {
+		name      string
+		clues     []int
+		typ       int
+		direction int
+	}{
+		{"top", top, OBS_COL, OBS_FWD},
+		{"bottom", bottom, OBS_COL, OBS_BWD},
+		{"left", left, OBS_ROW, OBS_FWD},
+		{"right", right, OBS_ROW, OBS_BWD},
+	} {
+		if len(edge.clues) != size {
+			return nil, fmt.Errorf("%s clue list has %d entries, expected %d", edge.name, len(edge.clues), size)
+		}
+		for i, count := range edge.clues {
+			err := b.AddObserver(&Observer{
+				Type:      edge.typ,
+				Index:     i,
+				Direction: edge.direction,
+				Count:     count,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("%s clue %d: %w", edge.name, i+1, err)
+			}
+		}
+	}
+	b.InitPerms()
+	return b, nil
+}
+
+// BoardsFromString parses a string holding several puzzles separated by one or
+// more empty lines, returning the boards in order. Comment lines are ignored
+// as in BoardFromString, and a chunk holding only comments is skipped. If a
+// puzzle fails to parse, the error gives its number in the input (counting
+// from 1) and the line it starts on; line numbers inside the wrapped error
+// count from the start of that puzzle.
+func BoardsFromString(input string) ([]*Board, error) {
+	out := make([]*Board, 0)
+	chunk := make([]string, 0)
+	start := 0
+	hasBoard := false
+	flush := func() error {
+		if hasBoard {
+			b, err := BoardFromString(strings.Join(chunk, "\n"))
+			if err != nil {
+				return fmt.Errorf("puzzle %d (line %d): %w", len(out)+1, start, err)
+			}
+			out = append(out, b)
+		}
+		chunk = chunk[:0]
+		hasBoard = false
+		return nil
+	}
+	for i, txt := range splitLines(input) {
+		if len(txt) == 0 {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if len(chunk) == 0 {
+			start = i + 1
+		}
+		chunk = append(chunk, txt)
+		if txt[0] != '#' {
+			hasBoard = true
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// splitLines splits input into lines, accepting Unix, Windows and old Mac
+// line endings alike.
+func splitLines(input string) []string {
+	input = strings.ReplaceAll(input, "\r\n", "\n")
+	input = strings.ReplaceAll(input, "\r", "\n")
+	return strings.Split(input, "\n")
+}
+
+// ParseOptions controls how BoardFromStringOpts builds a board.
+//
+// EmptyRune marks an empty cell or missing clue; if it is zero, '.' is used.
+// Spaces are always accepted as empty as well, so files written with spaces
+// still parse.
+//
+// SkipPerms sets NoPerms on the new board, so Perms is never generated and
+// RowPerms and ColPerms stay nil. The solver then relies on the heuristics
+// that work from Allowed alone and on backtracking, as SolveLowMemory does,
+// which keeps very large boards from running out of memory.
+type ParseOptions struct {
+	EmptyRune rune
+	SkipPerms bool
+}
+
+// BoardFromStringOpts is BoardFromString with caller-chosen options, and
+// without the MaxBoardSize limit. BoardFromString uses the zero ParseOptions.
+func BoardFromStringOpts(input string, opts ParseOptions) (*Board, error) {
+	emptyRune := opts.EmptyRune
+	if emptyRune == 0 {
+		emptyRune = '.'
+	}
 	lines := make([]string, 0)
+	lineNums := make([]int, 0)
 	inputs := make([][]int, 0)
-	for _, txt := range strings.Split(input, "\n") {
-		txt = strings.Trim(txt, "\r\n")
-		if len(txt) > 0 {
+	for i, txt := range splitLines(input) {
+		if len(txt) > 0 && txt[0] != '#' {
 			lines = append(lines, txt)
+			lineNums = append(lineNums, i+1)
 		}
 	}
-	b.Size = len(lines) - 2
-	b.Allowed = NewAllowed(b.Size)
-	b.NumEmpty = b.Size * b.Size
-	b.Observers = make([]*Observer, 0, b.Size*4)
-	b.ObsSorted = make([]*Observer, b.Size*4)
-	b.RowPerms = make([]*[]int, b.Size)
-	b.ColPerms = make([]*[]int, b.Size)
-	b.Grid = make([][]int, b.Size)
-	for i := 0; i < b.Size; i++ {
-		b.Grid[i] = make([]int, b.Size)
+	// A line of spaces at either end is padding, such as a stray trailing
+	// line, unless it is wide enough to be a border row with no clues.
+	for trimmed := true; trimmed && len(lines) > 3; {
+		trimmed = false
+		size := len(lines) - 2
+		for _, end := range []int{len(lines) - 1, 0} {
+			txt := lines[end]
+			width := utf8.RuneCountInString(txt)
+			if strings.TrimSpace(txt) != "" || width == size+1 || width == size+2 {
+				continue
+			}
+			lines = append(lines[:end], lines[end+1:]...)
+			lineNums = append(lineNums[:end], lineNums[end+1:]...)
+			trimmed = true
+			break
+		}
 	}
+	size := len(lines) - 2
+	if size < 1 {
+		return nil, fmt.Errorf("puzzle has %d lines, need at least 3", len(lines))
+	}
+	if size > MaxGlyph {
+		return nil, fmt.Errorf("board size %d exceeds maximum %d", size, MaxGlyph)
+	}
+	for ri, row := range lines {
+		width := utf8.RuneCountInString(row)
+		if width == size+2 {
+			continue
+		}
+		// The trailing corner of a border row is never read, so it may be
+		// left off.
+		if (ri == 0 || ri == size+1) && width == size+1 {
+			continue
+		}
+		return nil, fmt.Errorf("line %d has width %d, expected %d", lineNums[ri], width, size+2)
+	}
+	b := NewBoard(size)
+	b.NoPerms = opts.SkipPerms
 	for i := 0; i < b.Size+2; i++ {
 		inputs = append(inputs, make([]int, b.Size+2))
 	}
 	for ri, row := range lines {
-		for ci, ch := range row {
-			inputs[ri][ci] = ChToInt(ch)
+		for ci, ch := range []rune(row) {
+			if ch == emptyRune {
+				ch = ' '
+			}
+			// No tower line can show zero towers, so a 0 clue is almost
+			// certainly a typo. Blank border cells mean no clue.
+			rowEdge, colEdge := ri == 0 || ri == size+1, ci == 0 || ci == size+1
+			if ch == '0' && rowEdge != colEdge {
+				return nil, fmt.Errorf("line %d, column %d: clue 0 is impossible; leave the cell blank for no clue", lineNums[ri], ci+1)
+			}
+			n, err := ChToInt(ch)
+			if err != nil {
+				return nil, fmt.Errorf("line %d, column %d: %w", lineNums[ri], ci+1, err)
+			}
+			if n > size {
+				what := "value"
+				if rowEdge != colEdge {
+					what = "clue"
+				}
+				return nil, fmt.Errorf("line %d, column %d: %s %d exceeds board size %d", lineNums[ri], ci+1, what, n, size)
+			}
+			inputs[ri][ci] = n
 		}
 	}
 
@@ -333,7 +1511,9 @@ func BoardFromString(input string) (*Board, error) {
 				if ri == b.Size+1 {
 					obs.Direction = OBS_BWD
 				}
-				b.AddObserver(&obs)
+				if err := b.AddObserver(&obs); err != nil {
+					return nil, err
+				}
 				continue
 			}
 			if ci == 0 || ci == b.Size+1 {
@@ -346,31 +1526,112 @@ func BoardFromString(input string) (*Board, error) {
 				if ci == b.Size+1 {
 					obs.Direction = OBS_BWD
 				}
-				b.AddObserver(&obs)
+				if err := b.AddObserver(&obs); err != nil {
+					return nil, err
+				}
 				continue
 			}
 			b.Mark(ri-1, ci-1, cell)
+			b.Given[ri-1][ci-1] = cell != EMPTY
 		}
 	}
+	b.History = nil
+	b.InitPerms()
+	return b, nil
+}
+
+// NewBoard returns an empty board of the given size with no clues. Perms is
+// left nil until InitPerms is called, which the solvers do automatically.
+func NewBoard(size int) *Board {
+	b := Board{Size: size, mu: new(sync.RWMutex)}
+	b.Allowed = NewAllowed(b.Size)
+	b.NumEmpty = b.Size * b.Size
+	b.Observers = make([]*Observer, 0, b.Size*4)
+	b.ObsSorted = make([]*Observer, b.Size*4)
+	b.RowPerms = make([]*[]int, b.Size)
+	b.ColPerms = make([]*[]int, b.Size)
+	b.Grid = make([][]int, b.Size)
+	b.Given = make([][]bool, b.Size)
+	for i := 0; i < b.Size; i++ {
+		b.Grid[i] = make([]int, b.Size)
+		b.Given[i] = make([]bool, b.Size)
+	}
+	return &b
+}
+
+// MaxPermSize is the largest board size for which InitPerms generates Perms.
+// There are Size! permutations, so at size 10 they already take hundreds of
+// megabytes and at 12 they no longer fit in memory. Larger boards are solved
+// without them, as if NoPerms were set.
+const MaxPermSize = 9
+
+// InitPerms generates Perms, computes each line's permutation list from its
+// observers and trims Allowed to match. Does nothing if Perms has already been
+// generated, if NoPerms is set or if the board is larger than MaxPermSize. It
+// also does nothing if the board has no empty cells, since a filled board only
+// needs checking, or no observers, since every line's list would be nil.
+func (b *Board) InitPerms() {
+	if b.Perms != nil || b.NoPerms || b.Size > MaxPermSize || b.NumEmpty == 0 || len(b.Observers) == 0 {
+		return
+	}
+	b.GeneratePerms()
+}
+
+// GeneratePerms does the work of InitPerms without checking whether it should,
+// so it generates Perms even for boards larger than MaxPermSize or with
+// NoPerms set. The caller is responsible for having the memory to spare; see
+// EstimatePermMemory.
+func (b *Board) GeneratePerms() {
 	b.Perms = PermuteN(b.Size)
-	b.PopulateRowColPerms()
+	b.PopulateRowColPermsParallel()
+	b.TrimAllowedFromPerms()
+}
+
+// RebuildAllowed recomputes the candidate state from scratch after the grid
+// has been edited directly. Allowed is reset to full sets, every filled cell
+// is placed again with Mark, which also recounts NumEmpty, and RowPerms and
+// ColPerms are rederived from Perms and the observers. The result matches a
+// fresh parse of ToPuzzleString, except that Perms is kept even if the grid
+// is now full. Given is kept.
+//
+// The rebuild doesn't go through the trail, and the old Allowed lists it
+// replaces are what History, Push and Reasons describe, so all of them are
+// cleared: Undo and Redo have nothing to act on afterwards, and any Push in
+// effect is dropped, so a later Pop returns an error.
+func (b *Board) RebuildAllowed() {
+	grid := b.CopyGrid()
+	b.trail, b.frames = nil, nil
+	b.Allowed = NewAllowed(b.Size)
+	for ri := 0; ri < b.Size; ri++ {
+		for ci := 0; ci < b.Size; ci++ {
+			b.Grid[ri][ci] = EMPTY
+		}
+	}
+	b.NumEmpty = b.Size * b.Size
+	for ri := 0; ri < b.Size; ri++ {
+		for ci := 0; ci < b.Size; ci++ {
+			if grid[ri][ci] != EMPTY {
+				b.Mark(ri, ci, grid[ri][ci])
+			}
+		}
+	}
+	b.History, b.Undone = nil, nil
+	if b.Reasons != nil {
+		b.Reasons = make(map[CellKey][]Elimination)
+	}
+	if b.Perms == nil {
+		b.InitPerms()
+		return
+	}
+	b.PopulateRowColPermsParallel()
 	b.TrimAllowedFromPerms()
-	fmt.Printf("After init, numEmpty %d\n", b.NumEmpty)
-	return &b, nil
 }
 
 // ObsChar is a helper function that locates the observer specified by the
 // t(ype), index and direction parameters, then returns a string to be
 // displayed in the board string.
 func (b *Board) ObsChar(t, index, direction int) string {
-	idx := index * 2
-	if t == OBS_COL {
-		idx += b.Size * 2
-	}
-	if direction == OBS_BWD {
-		idx += 1
-	}
-	o := b.ObsSorted[idx]
+	o := b.ObsSorted[b.obsIndex(t, index, direction)]
 	if o == nil {
 		return " "
 	}
@@ -406,45 +1667,133 @@ func (b *Board) String() string {
 	return out
 }
 
-func (b *Board) PrintGrid() {
-	for _, row := range b.Grid {
+// WriteTo writes the board to w as ToPuzzleString renders it, so a board can
+// be saved next to its puzzle and loaded again later, solved or not.
+func (b *Board) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, b.ToPuzzleString())
+	return int64(n), err
+}
+
+// StringWithCounts renders the board like String, but each empty cell shows
+// the number of candidates it has left rather than a 0, so cells about to be
+// marked stand out as 1s. Filled cells show their values, so a count can't be
+// told apart from a value of the same size; use PrintAllowed for detail.
+func (b *Board) StringWithCounts() string {
+	out := " "
+	for ci := 0; ci < b.Size; ci++ {
+		out += b.ObsChar(OBS_COL, ci, OBS_FWD)
+	}
+	out += "\n"
+	for ri := 0; ri < b.Size; ri++ {
+		out += b.ObsChar(OBS_ROW, ri, OBS_FWD)
+		for ci := 0; ci < b.Size; ci++ {
+			if b.Get(ri, ci) == EMPTY {
+				out += string(IntToCh(b.CandidateCount(ri, ci)))
+			} else {
+				out += b.CharAt(ri, ci)
+			}
+		}
+		out += b.ObsChar(OBS_ROW, ri, OBS_BWD)
+		out += "\n"
+	}
+	out += " "
+	for ci := 0; ci < b.Size; ci++ {
+		out += b.ObsChar(OBS_COL, ci, OBS_BWD)
+	}
+	return out
+}
+
+// ToPuzzleString generates a string in the bordered format accepted by
+// BoardFromString. Unlike String, the corners are included and empty cells
+// and missing observers are rendered as spaces, so the output can be saved and
+// parsed again to recover the same board.
+func (b *Board) ToPuzzleString() string {
+	out := " "
+	for ci := 0; ci < b.Size; ci++ {
+		out += b.ObsChar(OBS_COL, ci, OBS_FWD)
+	}
+	out += " \n"
+	for ri := 0; ri < b.Size; ri++ {
+		out += b.ObsChar(OBS_ROW, ri, OBS_FWD)
+		for ci := 0; ci < b.Size; ci++ {
+			if b.Get(ri, ci) == EMPTY {
+				out += " "
+			} else {
+				out += b.CharAt(ri, ci)
+			}
+		}
+		out += b.ObsChar(OBS_ROW, ri, OBS_BWD)
+		out += "\n"
+	}
+	out += " "
+	for ci := 0; ci < b.Size; ci++ {
+		out += b.ObsChar(OBS_COL, ci, OBS_BWD)
+	}
+	out += " \n"
+	return out
+}
+
+// FormatGrid renders a grid of values one row per line, using IntToCh for
+// each cell.
+func FormatGrid(g [][]int) string {
+	out := ""
+	for _, row := range g {
 		for _, cell := range row {
-			fmt.Printf("%d", cell)
+			out += string(IntToCh(cell))
 		}
-		fmt.Printf("\n")
+		out += "\n"
 	}
+	return out
 }
 
 // Set saves an entry in the grid and updates NumEmpty.
 func (b *Board) Set(ri, ci, val int) bool {
-	if b.Get(ri, ci) == val {
+	old := b.Get(ri, ci)
+	if old == val {
 		return false
 	}
+	b.record(trailEntry{kind: trailCell, row: ri, col: ci, val: old})
+	b.setGrid(ri, ci, val)
+	return true
+}
+
+// setGrid does the work of Set without recording the change for Pop.
+func (b *Board) setGrid(ri, ci, val int) {
 	if b.Get(ri, ci) != EMPTY && val == EMPTY {
 		b.NumEmpty++
 	} else if b.Get(ri, ci) == EMPTY && val != EMPTY {
 		b.NumEmpty--
 	}
 	b.Grid[ri][ci] = val
+}
+
+// disallow removes n from the Allowed list of the specified cell, recording
+// the change for Pop. Returns true iff n was allowed.
+func (b *Board) disallow(ri, ci, n int) bool {
+	if !b.Allowed[ri][ci].Has(n) {
+		return false
+	}
+	b.Allowed[ri][ci].Delete(n)
+	b.record(trailEntry{kind: trailCandidate, row: ri, col: ci, val: n})
 	return true
 }
 
-// NumSet generates a set (i.e., a map[int]interface{}) containing the positive
-// integers from 1 to n inclusive.
-func NumSet(n int) map[int]interface{} {
-	out := make(map[int]interface{})
+// NumSet generates a set containing the positive integers from 1 to n
+// inclusive.
+func NumSet(n int) Set[int] {
+	out := NewSet[int]()
 	for i := 1; i <= n; i++ {
-		out[i] = nil
+		out.Add(i)
 	}
 	return out
 }
 
 // NewAllowed populates the Allowed slice with a new set from NumSet for each
 // location.
-func NewAllowed(n int) [][]map[int]interface{} {
-	out := make([][]map[int]interface{}, 0)
+func NewAllowed(n int) [][]Set[int] {
+	out := make([][]Set[int], 0)
 	for ri := 0; ri < n; ri++ {
-		out = append(out, make([]map[int]interface{}, 0))
+		out = append(out, make([]Set[int], 0))
 		for ci := 0; ci < n; ci++ {
 			out[ri] = append(out[ri], NumSet(n))
 		}
@@ -0,0 +1,575 @@
+package towers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// oracleVisible counts the towers visible from the start of line the slow
+// way: a tower is visible iff every tower in front of it is shorter.
+func oracleVisible(line []int) int {
+	count := 0
+	for i, v := range line {
+		visible := true
+		for _, w := range line[:i] {
+			if w >= v {
+				visible = false
+				break
+			}
+		}
+		if visible {
+			count++
+		}
+	}
+	return count
+}
+
+// reversed returns a reversed copy of line.
+func reversed(line []int) []int {
+	out := make([]int, len(line))
+	for i, v := range line {
+		out[len(line)-1-i] = v
+	}
+	return out
+}
+
+func TestPermFitsObsOracle(t *testing.T) {
+	for n := 1; n <= 6; n++ {
+		for _, p := range PermuteN(n) {
+			fwdVis, bwdVis := oracleVisible(p), oracleVisible(reversed(p))
+			for count := 1; count <= n; count++ {
+				fwd := &Observer{Type: OBS_ROW, Direction: OBS_FWD, Count: count}
+				bwd := &Observer{Type: OBS_ROW, Direction: OBS_BWD, Count: count}
+				if got, want := PermFitsObs(p, fwd, nil), fwdVis == count; got != want {
+					t.Errorf("PermFitsObs(%v, fwd %d, nil) = %v, want %v", p, count, got, want)
+				}
+				if got, want := PermFitsObs(p, nil, bwd), bwdVis == count; got != want {
+					t.Errorf("PermFitsObs(%v, nil, bwd %d) = %v, want %v", p, count, got, want)
+				}
+				for other := 1; other <= n; other++ {
+					bwd.Count = other
+					want := fwdVis == count && bwdVis == other
+					if got := PermFitsObs(p, fwd, bwd); got != want {
+						t.Errorf("PermFitsObs(%v, fwd %d, bwd %d) = %v, want %v", p, count, other, got, want)
+					}
+				}
+			}
+			if !PermFitsObs(p, nil, nil) {
+				t.Errorf("PermFitsObs(%v, nil, nil) = false, want true", p)
+			}
+		}
+	}
+}
+
+func TestObserverSatisfiedOracle(t *testing.T) {
+	// Every permutation as the first row and the first column of an
+	// otherwise empty board.
+	for n := 1; n <= 6; n++ {
+		for _, p := range PermuteN(n) {
+			rowBoard, colBoard := NewBoard(n), NewBoard(n)
+			for i, v := range p {
+				rowBoard.Mark(0, i, v)
+				colBoard.Mark(i, 0, v)
+			}
+			fwdVis, bwdVis := oracleVisible(p), oracleVisible(reversed(p))
+			for count := 1; count <= n; count++ {
+				for _, c := range []struct {
+					b   *Board
+					typ int
+				}{{rowBoard, OBS_ROW}, {colBoard, OBS_COL}} {
+					fwd := &Observer{Type: c.typ, Index: 0, Direction: OBS_FWD, Count: count}
+					bwd := &Observer{Type: c.typ, Index: 0, Direction: OBS_BWD, Count: count}
+					if got, want := c.b.ObserverSatisfied(fwd), fwdVis == count; got != want {
+						t.Errorf("%v: ObserverSatisfied(%s) = %v, want %v", p, fwd, got, want)
+					}
+					if got, want := c.b.ObserverSatisfied(bwd), bwdVis == count; got != want {
+						t.Errorf("%v: ObserverSatisfied(%s) = %v, want %v", p, bwd, got, want)
+					}
+				}
+			}
+		}
+	}
+	// Every line of random full grids.
+	rng := rand.New(rand.NewSource(1))
+	for n := 1; n <= 6; n++ {
+		for trial := 0; trial < 20; trial++ {
+			g := randomLatinSquare(n, rng)
+			b := NewBoard(n)
+			for ri := range g {
+				for ci, v := range g[ri] {
+					b.Mark(ri, ci, v)
+				}
+			}
+			for i := 0; i < n; i++ {
+				row := append([]int(nil), g[i]...)
+				col := make([]int, n)
+				for j := range col {
+					col[j] = g[j][i]
+				}
+				for _, line := range []struct {
+					typ  int
+					vals []int
+				}{{OBS_ROW, row}, {OBS_COL, col}} {
+					for dir, vis := range []int{oracleVisible(line.vals), oracleVisible(reversed(line.vals))} {
+						o := &Observer{Type: line.typ, Index: i, Direction: dir, Count: vis}
+						if !b.ObserverSatisfied(o) {
+							t.Errorf("grid %v: ObserverSatisfied(%s) = false, want true", g, o)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func FuzzBoardFromString(f *testing.F) {
+	for _, name := range append(bundledPuzzles, "problem1-solved.txt") {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(data))
+	}
+	f.Add("")
+	f.Add(" 1 \n1 1\n 1 ")
+	f.Fuzz(func(t *testing.T, input string) {
+		b, err := BoardFromString(input)
+		if err != nil {
+			return
+		}
+		if b.Size < 1 || b.Size > MaxBoardSize || len(b.Grid) != b.Size || len(b.Allowed) != b.Size {
+			t.Fatalf("board of size %d has %d rows and %d Allowed rows", b.Size, len(b.Grid), len(b.Allowed))
+		}
+		empty := 0
+		for ri := 0; ri < b.Size; ri++ {
+			if len(b.Grid[ri]) != b.Size || len(b.Allowed[ri]) != b.Size || len(b.Given[ri]) != b.Size {
+				t.Fatalf("row %d has the wrong width", ri)
+			}
+			for ci, v := range b.Grid[ri] {
+				if v < EMPTY || v > b.Size {
+					t.Fatalf("cell (%d, %d) holds %d", ri, ci, v)
+				}
+				if v == EMPTY {
+					empty++
+				}
+			}
+		}
+		if empty != b.NumEmpty {
+			t.Fatalf("NumEmpty is %d, grid has %d empty cells", b.NumEmpty, empty)
+		}
+		for _, o := range b.Observers {
+			if o.Index < 0 || o.Index >= b.Size || o.Count < 1 || o.Count > b.Size {
+				t.Fatalf("observer %s is off the board", o)
+			}
+		}
+		_ = b.String()
+		again, err := BoardFromString(b.ToPuzzleString())
+		if err != nil {
+			t.Fatalf("ToPuzzleString doesn't re-parse: %v\n%s", err, b.ToPuzzleString())
+		}
+		if !again.EqualsStrict(b) {
+			t.Fatalf("re-parsed board differs:\n%s\nvs\n%s", again, b)
+		}
+	})
+}
+
+func TestToPuzzleStringRoundTrip(t *testing.T) {
+	for _, name := range append(bundledPuzzles, "problem1-solved.txt") {
+		puzzle := loadPuzzle(t, name)
+		solved := puzzle.Clone()
+		solved.Solve()
+		for _, b := range []*Board{puzzle, solved} {
+			again, err := BoardFromString(b.ToPuzzleString())
+			if err != nil {
+				t.Fatalf("%s: re-parsing ToPuzzleString: %v\n%s", name, err, b.ToPuzzleString())
+			}
+			if !again.Equals(b) {
+				t.Errorf("%s: re-parsed board differs:\n%s\nwant\n%s", name, again, b)
+			}
+			for i, o := range b.ObsSorted {
+				if p := again.ObsSorted[i]; (o == nil) != (p == nil) || (o != nil && *o != *p) {
+					t.Errorf("%s: observer slot %d is %v after the round trip, want %v", name, i, p, o)
+				}
+			}
+		}
+	}
+}
+
+func TestWriteToRoundTrip(t *testing.T) {
+	for _, name := range bundledPuzzles {
+		b := loadPuzzle(t, name)
+		var sb strings.Builder
+		n, err := b.WriteTo(&sb)
+		if err != nil || n != int64(sb.Len()) {
+			t.Fatalf("%s: WriteTo = %d, %v; wrote %d bytes", name, n, err, sb.Len())
+		}
+		if got, want := sb.String(), b.ToPuzzleString(); got != want {
+			t.Errorf("%s: WriteTo wrote\n%s\nwant ToPuzzleString\n%s", name, got, want)
+		}
+		again, err := BoardFromString(sb.String())
+		if err != nil {
+			t.Fatalf("%s: re-parsing WriteTo output: %v\n%s", name, err, sb.String())
+		}
+		if !again.Equals(b) {
+			t.Errorf("%s: re-parsed board differs:\n%s\nwant\n%s", name, again, b)
+		}
+	}
+}
+
+// fittingPerms returns the permutations of 1 to n that fit the clues fwd and
+// bwd, either of which may be nil, in PermuteN order.
+func fittingPerms(n int, fwd, bwd *Observer) [][]int {
+	var out [][]int
+	for _, p := range PermuteN(n) {
+		if PermFitsObs(p, fwd, bwd) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func TestEditObserverUpdatesPerms(t *testing.T) {
+	b := NewBoard(5)
+	b.SetEdgeClue(OBS_ROW, 0, OBS_FWD, 2)
+	b.SetEdgeClue(OBS_COL, 1, OBS_BWD, 3)
+	b.InitPerms()
+	clue := func(typ, dir, count int) *Observer {
+		return &Observer{Type: typ, Direction: dir, Count: count}
+	}
+	check := func(step string, typ, index int, want [][]int) {
+		t.Helper()
+		if got := b.LinePerms(typ, index); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: line has %d perms, want %d", step, len(got), len(want))
+		}
+	}
+	check("start", OBS_ROW, 0, fittingPerms(5, clue(OBS_ROW, OBS_FWD, 2), nil))
+
+	b.SetObserverCount(OBS_ROW, 0, OBS_FWD, 3)
+	check("fwd clue edited", OBS_ROW, 0, fittingPerms(5, clue(OBS_ROW, OBS_FWD, 3), nil))
+	b.SetObserverCount(OBS_ROW, 0, OBS_BWD, 2)
+	check("bwd clue added", OBS_ROW, 0, fittingPerms(5, clue(OBS_ROW, OBS_FWD, 3), clue(OBS_ROW, OBS_BWD, 2)))
+	b.RemoveObserver(OBS_ROW, 0, OBS_FWD)
+	check("fwd clue removed", OBS_ROW, 0, fittingPerms(5, nil, clue(OBS_ROW, OBS_BWD, 2)))
+	b.RemoveObserver(OBS_ROW, 0, OBS_BWD)
+	check("both clues removed", OBS_ROW, 0, nil)
+
+	b.SetObserverCount(OBS_COL, 1, OBS_BWD, 1)
+	check("col clue edited", OBS_COL, 1, fittingPerms(5, nil, clue(OBS_COL, OBS_BWD, 1)))
+	b.SetObserverCount(OBS_COL, 1, OBS_BWD, 0)
+	check("col clue cleared", OBS_COL, 1, nil)
+}
+
+func TestTooLargeGiven(t *testing.T) {
+	puzzle := strings.Join([]string{
+		" 3214",
+		"3    2",
+		"2 7  2",
+		"1    2",
+		"4    1",
+		" 2221",
+	}, "\n")
+	_, err := BoardFromString(puzzle)
+	if err == nil || !strings.Contains(err.Error(), "line 3, column 3: value 7 exceeds board size 4") {
+		t.Errorf("parsing a given of 7 on a size-4 board: got error %v", err)
+	}
+	_, err = BoardFromString(strings.Replace(puzzle, "7", "z", 1))
+	if err == nil || !strings.Contains(err.Error(), "line 3, column 3") {
+		t.Errorf("parsing a given of z on a size-4 board: got error %v", err)
+	}
+
+	b := loadPuzzle(t, "problem1.txt")
+	data, err := b.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cp map[string]interface{}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		t.Fatal(err)
+	}
+	cp["Grid"].([]interface{})[1].([]interface{})[1] = 7
+	data, _ = json.Marshal(cp)
+	_, err = LoadCheckpoint(data)
+	if err == nil || !strings.Contains(err.Error(), "cell (1, 1): value 7 is out of range") {
+		t.Errorf("loading a checkpoint with a given of 7 on a size-4 board: got error %v", err)
+	}
+}
+
+func TestGlyphRoundTrip(t *testing.T) {
+	seen := map[rune]int{}
+	for n := 0; n <= MaxGlyph; n++ {
+		ch := IntToCh(n)
+		if prev, ok := seen[ch]; ok {
+			t.Errorf("IntToCh(%d) = IntToCh(%d) = %q", n, prev, ch)
+		}
+		seen[ch] = n
+		if got, err := ChToInt(ch); err != nil || got != n {
+			t.Errorf("ChToInt(IntToCh(%d) = %q) = %d, %v", n, ch, got, err)
+		}
+	}
+	for _, n := range []int{-1, MaxGlyph + 1, 100} {
+		if ch := IntToCh(n); ch != '?' {
+			t.Errorf("IntToCh(%d) = %q, want '?'", n, ch)
+		}
+	}
+	for _, ch := range []rune{'?', '!', '-', 'é', '\t'} {
+		if n, err := ChToInt(ch); err == nil {
+			t.Errorf("ChToInt(%q) = %d, want an error", ch, n)
+		}
+	}
+	if _, err := BoardFromString(" 1 \n1!1\n 1 "); err == nil || !strings.Contains(err.Error(), "line 2, column 2") {
+		t.Errorf("parsing an invalid glyph: got error %v", err)
+	}
+}
+
+func TestCommentsParseToSameBoard(t *testing.T) {
+	plain, err := os.ReadFile("problem3.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(string(plain), "\n"), "\n")
+	commented := []string{"# problem 3", "# from the bundled samples", ""}
+	for i, line := range lines {
+		commented = append(commented, line)
+		if i%2 == 0 {
+			commented = append(commented, fmt.Sprintf("# after line %d", i+1), "")
+		}
+	}
+	want, err := BoardFromString(string(plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := BoardFromString(strings.Join(commented, "\n"))
+	if err != nil {
+		t.Fatalf("parsing with comments: %v", err)
+	}
+	if !got.EqualsStrict(want) {
+		t.Errorf("with comments the board is\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestNewBoardString(t *testing.T) {
+	// String shows empty cells as 0 and missing clues as spaces.
+	for n := 1; n <= 6; n++ {
+		b := NewBoard(n)
+		border := strings.Repeat(" ", n+1)
+		want := border + "\n" + strings.Repeat(" "+strings.Repeat("0", n)+" \n", n) + border
+		if got := b.String(); got != want {
+			t.Errorf("NewBoard(%d).String() = %q, want %q", n, got, want)
+		}
+		if len(b.Observers) != 0 || b.NumEmpty != n*n || b.Perms != nil {
+			t.Errorf("NewBoard(%d) has %d observers, NumEmpty %d, Perms %v", n, len(b.Observers), b.NumEmpty, b.Perms != nil)
+		}
+	}
+}
+
+func TestLetterCluesSize12(t *testing.T) {
+	puzzle := strings.Join([]string{
+		" 434343232321 ",
+		"c123456789abc1",
+		"7            2",
+		"2            2",
+		"9456789abc1232",
+		"4            2",
+		"b            2",
+		"6789abc1234562",
+		"1            2",
+		"8            2",
+		"3abc1234567892",
+		"a            2",
+		"5            2",
+		" 332214433244 ",
+	}, "\n")
+	b, err := BoardFromString(puzzle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ index, count int }{{0, 12}, {5, 11}, {10, 10}} {
+		if o := b.ObsSorted[b.obsIndex(OBS_ROW, c.index, OBS_FWD)]; o == nil || o.Count != c.count {
+			t.Errorf("row %d left clue is %v, want %d", c.index, o, c.count)
+		}
+	}
+	if res := b.Solve(); res.Err != nil {
+		t.Fatalf("Solve: %v", res.Err)
+	}
+	if err := b.Solved(); err != nil {
+		t.Fatalf("Solved: %v\n%s", err, b)
+	}
+	out := b.ToPuzzleString()
+	if lines := strings.Split(out, "\n"); lines[1][0] != 'c' || lines[6][0] != 'b' || lines[11][0] != 'a' {
+		t.Errorf("letter clues were not re-rendered:\n%s", out)
+	}
+	again, err := BoardFromString(out)
+	if err != nil {
+		t.Fatalf("re-parsing the solved board: %v\n%s", err, out)
+	}
+	if !again.Equals(b) {
+		t.Errorf("re-parsed board differs:\n%s\nwant\n%s", again, b)
+	}
+	if _, err := BoardFromString(strings.Replace(puzzle, "c123", "d123", 1)); err == nil || !strings.Contains(err.Error(), "clue 13 exceeds board size 12") {
+		t.Errorf("parsing a clue of d on a size-12 board: got error %v", err)
+	}
+}
+
+func TestRebuildAllowedMatchesFreshParse(t *testing.T) {
+	for _, name := range bundledPuzzles {
+		b := loadPuzzle(t, name)
+		b.AutoSolve()
+		b.Push()
+		// Hand edits behind the solver's back: clear every third cell it
+		// filled.
+		n := 0
+		for ri := 0; ri < b.Size; ri++ {
+			for ci := 0; ci < b.Size; ci++ {
+				if b.Grid[ri][ci] != EMPTY && !b.Given[ri][ci] {
+					if n%3 == 0 {
+						b.Grid[ri][ci] = EMPTY
+					}
+					n++
+				}
+			}
+		}
+		b.RebuildAllowed()
+		want, err := BoardFromString(b.ToPuzzleString())
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !b.EqualsStrict(want) || b.NumEmpty != want.NumEmpty {
+			t.Errorf("%s: rebuilt board\n%s\ndiffers from a fresh parse\n%s", name, b.CandidatesString(), want.CandidatesString())
+		}
+		for i := 0; i < b.Size; i++ {
+			for _, typ := range []int{OBS_ROW, OBS_COL} {
+				if got, want := b.LinePerms(typ, i), want.LinePerms(typ, i); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s: line %d/%d has %d perms, a fresh parse %d", name, typ, i, len(got), len(want))
+				}
+			}
+		}
+		if err := b.CheckConsistency(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if len(b.History) != 0 || b.Pop() == nil {
+			t.Errorf("%s: RebuildAllowed kept %d History entries or a Push", name, len(b.History))
+		}
+	}
+}
+
+func TestLineEndingsParseToSameBoard(t *testing.T) {
+	data, err := os.ReadFile("problem6.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unix := strings.TrimRight(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	want, err := BoardFromString(unix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	variants := map[string]string{
+		"trailing newline":       unix + "\n",
+		"trailing blank line":    unix + "\n\n",
+		"leading blank line":     "\n" + unix + "\n",
+		"windows":                strings.ReplaceAll(unix, "\n", "\r\n") + "\r\n",
+		"windows, trailing line": strings.ReplaceAll(unix, "\n", "\r\n") + "\r\n\r\n",
+		"old mac":                strings.ReplaceAll(unix, "\n", "\r") + "\r",
+		"mixed":                  strings.Replace(unix, "\n", "\r\n", 3) + "\n",
+	}
+	for name, input := range variants {
+		got, err := BoardFromString(input)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !got.EqualsStrict(want) {
+			t.Errorf("%s: parsed to\n%s\nwant\n%s", name, got, want)
+		}
+	}
+}
+
+func TestCluePairChecksAgree(t *testing.T) {
+	for n := 1; n <= 6; n++ {
+		for f := 1; f <= n; f++ {
+			for k := 1; k <= n; k++ {
+				b := NewBoard(n)
+				if err := b.SetEdgeClue(OBS_ROW, 0, OBS_FWD, f); err != nil {
+					t.Fatal(err)
+				}
+				if err := b.SetEdgeClue(OBS_ROW, 0, OBS_BWD, k); err != nil {
+					t.Fatal(err)
+				}
+				fwd, bwd := b.ObserversFor(OBS_ROW, 0)
+				want := len(fittingPerms(n, fwd, bwd)) > 0
+				if got := b.CluePairPlausible(OBS_ROW, 0); got != want {
+					t.Errorf("size %d, clues %d and %d: CluePairPlausible = %v, want %v", n, f, k, got, want)
+				}
+				if err := b.ValidateObserverPairs(); (err == nil) != want {
+					t.Errorf("size %d, clues %d and %d: ValidateObserverPairs = %v, want ok = %v", n, f, k, err, want)
+				}
+			}
+		}
+	}
+}
+
+func TestSetCellClearAndOverwrite(t *testing.T) {
+	sameState := func(t *testing.T, what string, got, want *Board) {
+		t.Helper()
+		if !got.EqualsStrict(want) || got.NumEmpty != want.NumEmpty {
+			t.Fatalf("%s: board differs:\n%s\nwant\n%s", what, got, want)
+		}
+		for ri := 0; ri < got.Size; ri++ {
+			for ci := 0; ci < got.Size; ci++ {
+				if g, w := got.Candidates(ri, ci), want.Candidates(ri, ci); !reflect.DeepEqual(g, w) {
+					t.Errorf("%s: cell (%d, %d) allows %v, want %v", what, ri, ci, g, w)
+				}
+			}
+		}
+	}
+	for _, name := range bundledPuzzles {
+		puzzle := loadPuzzle(t, name)
+		ri, ci := -1, -1
+		for i := 0; i < puzzle.Size*puzzle.Size && ri < 0; i++ {
+			if r, c := i/puzzle.Size, i%puzzle.Size; puzzle.Get(r, c) == EMPTY && puzzle.CandidateCount(r, c) >= 2 {
+				ri, ci = r, c
+			}
+		}
+		if ri < 0 {
+			continue
+		}
+		vals := puzzle.Candidates(ri, ci)
+
+		b := puzzle.Clone()
+		if changed, err := b.SetCell(ri, ci, vals[0]); !changed || err != nil {
+			t.Fatalf("%s: SetCell(%d, %d, %d) = %v, %v", name, ri, ci, vals[0], changed, err)
+		}
+		if changed, err := b.SetCell(ri, ci, EMPTY); !changed || err != nil {
+			t.Fatalf("%s: clearing (%d, %d) = %v, %v", name, ri, ci, changed, err)
+		}
+		if _, _, ok := b.FindContradiction(); ok {
+			t.Errorf("%s: clearing (%d, %d) left a cell with no candidates", name, ri, ci)
+		}
+		if b.Given[ri][ci] {
+			t.Errorf("%s: cleared cell (%d, %d) is still a given", name, ri, ci)
+		}
+		sameState(t, name+" after clearing", b, puzzle)
+
+		want := puzzle.Clone()
+		want.SetCell(ri, ci, vals[1])
+		b.SetCell(ri, ci, vals[0])
+		if changed, err := b.SetCell(ri, ci, vals[1]); !changed || err != nil {
+			t.Fatalf("%s: overwriting (%d, %d) = %v, %v", name, ri, ci, changed, err)
+		}
+		sameState(t, name+" after overwriting", b, want)
+
+		for _, v := range []int{-1, b.Size + 1} {
+			if _, err := b.SetCell(ri, ci, v); err == nil {
+				t.Errorf("%s: SetCell(%d, %d, %d) succeeded", name, ri, ci, v)
+			}
+		}
+		if _, err := b.SetCell(b.Size, 0, 1); err == nil {
+			t.Errorf("%s: SetCell off the board succeeded", name)
+		}
+		sameState(t, name+" after rejected SetCells", b, want)
+	}
+}
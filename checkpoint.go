@@ -0,0 +1,221 @@
+package towers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// checkpoint is the serialized form of a board's solving state. Allowed lists
+// are stored as sorted slices. RowPerms and ColPerms hold indices into the
+// board's Perms, which is regenerated from Size on load; a nil entry means the
+// line is unconstrained. HasPerms records whether Perms had been generated,
+// and NoPerms is the board's NoPerms, so a resumed solve runs the same
+// heuristics.
+type checkpoint struct {
+	Size      int
+	Grid      [][]int
+	Given     [][]bool
+	Allowed   [][][]int
+	Observers []Observer
+	HasPerms  bool
+	NoPerms   bool
+	RowPerms  [][]int
+	ColPerms  [][]int
+}
+
+// Checkpoint serializes the board's in-progress state, including every Allowed
+// list and the remaining permutations for each line, so that LoadCheckpoint
+// can resume solving exactly where it left off. Observers are saved in
+// ObsSorted order, so the output doesn't depend on the order the clues were
+// added in. History is not saved.
+func (b *Board) Checkpoint() ([]byte, error) {
+	cp := checkpoint{
+		Size:     b.Size,
+		Grid:     b.CopyGrid(),
+		Given:    b.Given,
+		Allowed:  make([][][]int, b.Size),
+		HasPerms: b.Perms != nil,
+		NoPerms:  b.NoPerms,
+		RowPerms: make([][]int, b.Size),
+		ColPerms: make([][]int, b.Size),
+	}
+	for ri := 0; ri < b.Size; ri++ {
+		cp.Allowed[ri] = make([][]int, b.Size)
+		for ci := 0; ci < b.Size; ci++ {
+			vals := make([]int, 0, b.Allowed[ri][ci].Len())
+			for n := 1; n <= b.Size; n++ {
+				if b.IsAllowed(ri, ci, n) {
+					vals = append(vals, n)
+				}
+			}
+			cp.Allowed[ri][ci] = vals
+		}
+	}
+	for _, o := range b.Observers {
+		cp.Observers = append(cp.Observers, *o)
+	}
+	sort.Slice(cp.Observers, func(i, j int) bool {
+		oi, oj := cp.Observers[i], cp.Observers[j]
+		return b.obsIndex(oi.Type, oi.Index, oi.Direction) < b.obsIndex(oj.Type, oj.Index, oj.Direction)
+	})
+	saved := func(list []int) []int {
+		if !b.permsCompacted() {
+			return list
+		}
+		// LoadCheckpoint regenerates the full Perms, so save each
+		// permutation's index in that.
+		out := make([]int, len(list))
+		for i, pi := range list {
+			out[i] = permRank(b.Perms[pi])
+		}
+		return out
+	}
+	for i := 0; i < b.Size; i++ {
+		if b.RowPerms[i] != nil {
+			cp.RowPerms[i] = saved(*b.RowPerms[i])
+		}
+		if b.ColPerms[i] != nil {
+			cp.ColPerms[i] = saved(*b.ColPerms[i])
+		}
+	}
+	return json.Marshal(cp)
+}
+
+// LoadCheckpoint restores a board saved by Checkpoint. Perms is regenerated
+// with InitPerms, so the saved permutation indices refer to the same
+// permutations as before, and the saved Allowed lists and permutation lists
+// then replace the ones InitPerms derived. If InitPerms declines to generate
+// Perms, because the board is full or larger than MaxPermSize, the saved
+// permutation lists are dropped and the board is solved without them, as
+// AutoSolve would solve it.
+func LoadCheckpoint(data []byte) (*Board, error) {
+	cp := checkpoint{}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	if cp.Size < 0 || len(cp.Grid) != cp.Size || len(cp.Given) != cp.Size || len(cp.Allowed) != cp.Size ||
+		len(cp.RowPerms) != cp.Size || len(cp.ColPerms) != cp.Size {
+		return nil, fmt.Errorf("checkpoint is inconsistent with size %d", cp.Size)
+	}
+	b := NewBoard(cp.Size)
+	b.NoPerms = cp.NoPerms
+	for i := range cp.Observers {
+		o := cp.Observers[i]
+		if err := b.AddObserver(&o); err != nil {
+			return nil, fmt.Errorf("checkpoint: %w", err)
+		}
+	}
+	for ri := 0; ri < b.Size; ri++ {
+		if len(cp.Grid[ri]) != b.Size || len(cp.Given[ri]) != b.Size || len(cp.Allowed[ri]) != b.Size {
+			return nil, fmt.Errorf("checkpoint row %d is inconsistent with size %d", ri, b.Size)
+		}
+		for ci := 0; ci < b.Size; ci++ {
+			if v := cp.Grid[ri][ci]; v < EMPTY || v > b.Size {
+				return nil, fmt.Errorf("checkpoint cell (%d, %d): value %d is out of range 0 to %d", ri, ci, v, b.Size)
+			}
+			for _, n := range cp.Allowed[ri][ci] {
+				if n < 1 || n > b.Size {
+					return nil, fmt.Errorf("checkpoint cell (%d, %d): candidate %d is out of range 1 to %d", ri, ci, n, b.Size)
+				}
+			}
+			b.Set(ri, ci, cp.Grid[ri][ci])
+			b.Given[ri][ci] = cp.Given[ri][ci]
+		}
+	}
+	if cp.HasPerms {
+		b.InitPerms()
+	}
+	for ri := 0; ri < b.Size; ri++ {
+		for ci := 0; ci < b.Size; ci++ {
+			b.Allowed[ri][ci] = NewSet[int]()
+			for _, n := range cp.Allowed[ri][ci] {
+				b.Allowed[ri][ci].Add(n)
+			}
+		}
+	}
+	if b.Perms == nil {
+		return b, nil
+	}
+	// restore copies a saved list, so the board never shares a backing array
+	// with cp.
+	restore := func(saved []int) (*[]int, error) {
+		if saved == nil {
+			return nil, nil
+		}
+		for _, pi := range saved {
+			if pi < 0 || pi >= len(b.Perms) {
+				return nil, fmt.Errorf("checkpoint permutation index %d out of range", pi)
+			}
+		}
+		perms := make([]int, len(saved))
+		copy(perms, saved)
+		return &perms, nil
+	}
+	for i := 0; i < b.Size; i++ {
+		var err error
+		if b.RowPerms[i], err = restore(cp.RowPerms[i]); err != nil {
+			return nil, err
+		}
+		if b.ColPerms[i], err = restore(cp.ColPerms[i]); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// DumpState returns a description of the board's solving state for pasting
+// into a bug report: the puzzle as ToPuzzleString gives it, NumEmpty, the
+// candidates of every cell, the number of permutations left for each line and
+// the Checkpoint JSON, which LoadCheckpoint turns back into the same state.
+// Everything is listed in a fixed order, so two dumps of the same state are
+// identical. In the candidate grid a filled cell shows as '=' and its value
+// and an empty cell with no candidates left as '!'; in the permutation counts
+// '-' marks a line with no list.
+func (b *Board) DumpState() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "size %d, %d empty\npuzzle:\n%s", b.Size, b.NumEmpty, b.ToPuzzleString())
+	sb.WriteString("candidates:\n")
+	width := b.Size
+	if width < 2 {
+		width = 2
+	}
+	for ri := 0; ri < b.Size; ri++ {
+		cells := make([]string, b.Size)
+		for ci := 0; ci < b.Size; ci++ {
+			cell := "=" + b.CharAt(ri, ci)
+			if b.Get(ri, ci) == EMPTY {
+				vals := make([]rune, 0, b.Size)
+				for _, v := range b.Candidates(ri, ci) {
+					vals = append(vals, IntToCh(v))
+				}
+				cell = string(vals)
+				if cell == "" {
+					cell = "!"
+				}
+			}
+			cells[ci] = fmt.Sprintf("%-*s", width, cell)
+		}
+		fmt.Fprintf(&sb, "  %s\n", strings.TrimRight(strings.Join(cells, " "), " "))
+	}
+	sb.WriteString("perms:\n")
+	for line := 0; line < b.Size*2; line++ {
+		typ, index := "row", line
+		if line >= b.Size {
+			typ, index = "col", line-b.Size
+		}
+		count := "-"
+		if list := b.linePermsFor(line); list != nil {
+			count = fmt.Sprint(len(*list))
+		}
+		fmt.Fprintf(&sb, "  %s %d: %s\n", typ, index, count)
+	}
+	cp, err := b.Checkpoint()
+	if err != nil {
+		fmt.Fprintf(&sb, "checkpoint: %v\n", err)
+	} else {
+		fmt.Fprintf(&sb, "checkpoint:\n%s\n", cp)
+	}
+	return sb.String()
+}
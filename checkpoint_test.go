@@ -0,0 +1,105 @@
+package towers
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// linePermValues returns the permutations left for line as values rather
+// than indices, so boards with differently ordered Perms can be compared.
+func linePermValues(b *Board, line int) [][]int {
+	list := b.linePermsFor(line)
+	if list == nil {
+		return nil
+	}
+	out := make([][]int, len(*list))
+	for i, pi := range *list {
+		out[i] = b.Perms[pi]
+	}
+	return out
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	for _, name := range append(bundledPuzzles, "hardPuzzle8") {
+		for _, compact := range []bool{false, true} {
+			var b *Board
+			if name == "hardPuzzle8" {
+				b = hardBoard8(t)
+			} else {
+				b = loadPuzzle(t, name)
+				b.MarkMandatory()
+				b.TrimPermsFromAllowed()
+			}
+			if b.NumEmpty == 0 {
+				// InitPerms skips full boards, so there are no
+				// permutation lists to restore.
+				continue
+			}
+			if compact {
+				b.CompactPerms()
+			}
+			data, err := b.Checkpoint()
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := LoadCheckpoint(data)
+			if err != nil {
+				t.Fatalf("%s, compact %v: %v", name, compact, err)
+			}
+			if !c.EqualsStrict(b) || c.NumEmpty != b.NumEmpty {
+				t.Fatalf("%s, compact %v: loaded board differs:\n%s\nwant\n%s", name, compact, c, b)
+			}
+			for ri := 0; ri < b.Size; ri++ {
+				for ci := 0; ci < b.Size; ci++ {
+					if got, want := c.Candidates(ri, ci), b.Candidates(ri, ci); !reflect.DeepEqual(got, want) {
+						t.Errorf("%s, compact %v: cell (%d, %d) allows %v, want %v", name, compact, ri, ci, got, want)
+					}
+				}
+			}
+			if (c.Perms == nil) != (b.Perms == nil) {
+				t.Fatalf("%s, compact %v: loaded Perms nil = %v, want %v", name, compact, c.Perms == nil, b.Perms == nil)
+			}
+			for line := 0; line < b.Size*2; line++ {
+				if got, want := linePermValues(c, line), linePermValues(b, line); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s, compact %v: line %d has %d perms, want %d", name, compact, line, len(got), len(want))
+				}
+			}
+			again, err := c.Checkpoint()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(again, data) {
+				t.Errorf("%s, compact %v: checkpoint of the loaded board differs", name, compact)
+			}
+		}
+	}
+}
+
+func TestCheckpointKeepsNoPerms(t *testing.T) {
+	puzzle, _ := Generate(6, 2)
+	b := NewBoard(6)
+	b.NoPerms = true
+	for _, o := range puzzle.Observers {
+		b.SetEdgeClue(o.Type, o.Index, o.Direction, o.Count)
+	}
+	b.TrimByVisibility()
+	data, err := b.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadCheckpoint(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.NoPerms {
+		t.Fatal("NoPerms was not restored")
+	}
+	want, got := b.Solve(), c.Solve()
+	if c.Perms != nil {
+		t.Error("the resumed solve generated Perms")
+	}
+	if !reflect.DeepEqual(got.Fired, want.Fired) || got.Rounds != want.Rounds || !c.EqualsStrict(b) {
+		t.Errorf("resumed solve fired %v in %d rounds, want %v in %d", got.Fired, got.Rounds, want.Fired, want.Rounds)
+	}
+}
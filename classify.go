@@ -0,0 +1,134 @@
+package towers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Solvability says what kind of puzzle a file holds, as decided by
+// ClassifyLibrary.
+type Solvability int
+
+const (
+	// PuzzleLogical means the heuristics solve the puzzle on their own, so
+	// it has exactly one solution and no guessing is needed.
+	PuzzleLogical Solvability = iota
+	// PuzzleNeedsGuessing means the puzzle has exactly one solution, but the
+	// heuristics get stuck before reaching it.
+	PuzzleNeedsGuessing
+	// PuzzleAmbiguous means the puzzle has more than one solution.
+	PuzzleAmbiguous
+	// PuzzleUnsatisfiable means the puzzle has no solution.
+	PuzzleUnsatisfiable
+	// PuzzleMalformed means the file couldn't be read or parsed.
+	PuzzleMalformed
+)
+
+func (s Solvability) String() string {
+	switch s {
+	case PuzzleLogical:
+		return "solvable by logic"
+	case PuzzleNeedsGuessing:
+		return "needs guessing"
+	case PuzzleAmbiguous:
+		return "ambiguous"
+	case PuzzleUnsatisfiable:
+		return "unsatisfiable"
+	}
+	return "malformed"
+}
+
+// A Classification is ClassifyLibrary's verdict on one puzzle file. Deciding
+// rates the puzzle's difficulty: it is the hardest technique the solve
+// needed, as counted by HeuristicHistogram, or GuessingTechnique if the
+// heuristics got stuck. It is empty for puzzles that aren't uniquely
+// solvable. Stats covers the heuristic part of the solve, and Err is the
+// parse error or the error explaining why the heuristics stopped.
+type Classification struct {
+	Solvability Solvability
+	Deciding    string
+	Stats       SolveStats
+	Err         error
+}
+
+// A Library maps puzzle filenames to their classifications.
+type Library map[string]Classification
+
+// ClassifyLibrary walks the directory at dir like SolveDir and classifies
+// every puzzle file it finds. Each puzzle is run through the heuristics, and
+// if they get stuck, its solutions are counted to tell guessing apart from
+// ambiguous and unsatisfiable puzzles. A file that can't be parsed, or a
+// subdirectory that can't be read, is recorded as PuzzleMalformed and doesn't
+// stop the run; if dir itself can't be walked, the error is recorded as a
+// PuzzleMalformed entry under dir.
+func ClassifyLibrary(dir string) Library {
+	out := make(Library)
+	err := walkPuzzles(dir, func(p string, b *Board, err error) {
+		if err != nil {
+			out[p] = Classification{Solvability: PuzzleMalformed, Err: err}
+			return
+		}
+		out[p] = b.classify()
+	})
+	if err != nil {
+		out[dir] = Classification{Solvability: PuzzleMalformed, Err: err}
+	}
+	return out
+}
+
+// classify solves b with the heuristics and classifies it as described in
+// ClassifyLibrary.
+func (b *Board) classify() Classification {
+	stats := NewSolveStats()
+	err := b.autoSolve(context.Background(), stats)
+	if err != nil && !errors.Is(err, ErrUnsatisfiable) && !errors.Is(err, ErrAmbiguous) {
+		err = b.stallError(context.Background())
+	}
+	c := Classification{Stats: *stats, Err: err}
+	switch {
+	case err == nil:
+		c.Solvability = PuzzleLogical
+		c.Deciding = b.decidingTechnique(stats.Fired, false)
+	case errors.Is(err, ErrNeedsGuessing):
+		c.Solvability = PuzzleNeedsGuessing
+		c.Deciding = b.decidingTechnique(stats.Fired, true)
+	case errors.Is(err, ErrAmbiguous):
+		c.Solvability = PuzzleAmbiguous
+	case errors.Is(err, ErrUnsatisfiable):
+		c.Solvability = PuzzleUnsatisfiable
+	default:
+		c.Solvability = PuzzleMalformed
+	}
+	return c
+}
+
+// Filenames returns the filenames in l in sorted order.
+func (l Library) Filenames() []string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// String reports one line per puzzle, sorted by filename, with the deciding
+// technique for puzzles solvable by logic and the error for malformed ones.
+func (l Library) String() string {
+	var sb strings.Builder
+	for _, name := range l.Filenames() {
+		c := l[name]
+		fmt.Fprintf(&sb, "%s: %s", name, c.Solvability)
+		switch {
+		case c.Solvability == PuzzleLogical && c.Deciding != "":
+			fmt.Fprintf(&sb, " (%s)", c.Deciding)
+		case c.Solvability == PuzzleMalformed && c.Err != nil:
+			fmt.Fprintf(&sb, " (%v)", c.Err)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,96 @@
+// Command towers solves a towers puzzle read from a file, printing each step,
+// or lets the user solve it by hand with -i.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/bismuthsalamander/towers"
+)
+
+func main() {
+	force := flag.Bool("force", false, fmt.Sprintf("generate permutations even for boards larger than %d, which can take gigabytes", towers.MaxPermSize))
+	output := flag.String("o", "", "write the board to this file after solving, or to stdout if \"-\"")
+	interactive := flag.Bool("i", false, "solve the puzzle by hand, one command at a time")
+	flag.Parse()
+	filename := "problem6.txt"
+	if flag.NArg() > 0 {
+		filename = flag.Arg(0)
+	}
+	b, err := towers.BoardFromFile(filename)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *interactive {
+		if err := runREPL(b, os.Stdin, os.Stdout); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	fmt.Printf("%v\n", b)
+	fmt.Printf("After init, numEmpty %d\n", b.NumEmpty)
+	count, bytes := towers.EstimatePermMemory(b.Size)
+	fmt.Printf("Permutations: %d, about %d MiB\n", count, bytes>>20)
+	if _, limit := towers.EstimatePermMemory(towers.MaxPermSize); bytes > limit {
+		if *force {
+			b.GeneratePerms()
+		} else {
+			fmt.Printf("Solving without permutations; use -force to generate them\n")
+		}
+	}
+	b.Verbose = true
+	a := b.Analyze()
+	b.AutoSolve()
+	err = b.Solved()
+	fmt.Printf("Board:\n%s\nEmpty %d\nSolved: %s\n", b, b.NumEmpty, err)
+	fmt.Printf("Analysis: %s\n", a)
+	if a.Count > 1 {
+		for i, g := range a.Solutions {
+			fmt.Printf("Solution %d:\n%s", i+1, towers.FormatGrid(g))
+		}
+	}
+	if *output != "" {
+		if err := writeBoard(b, *output, filename, err == nil); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	return
+}
+
+// writeBoard saves b to the file out, or to stdout if out is "-". A board
+// that isn't solved is never written over the puzzle it was read from, so a
+// failed solve can't clobber the input.
+func writeBoard(b *towers.Board, out, input string, solved bool) error {
+	if out == "-" {
+		_, err := b.WriteTo(os.Stdout)
+		return err
+	}
+	if !solved && sameFile(out, input) {
+		return fmt.Errorf("not writing unsolved board over %s", input)
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if _, err := b.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// sameFile reports whether the paths a and b name the same existing file.
+func sameFile(a, b string) bool {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fa, fb)
+}
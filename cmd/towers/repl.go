@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/bismuthsalamander/towers"
+)
+
+// replHelp lists the commands runREPL accepts.
+const replHelp = `Commands (rows and columns count from 0):
+  mark r c v      place v at row r, col c
+  candidates r c  list the values still allowed at row r, col c
+  hint            describe the next logical step
+  undo            take back the last mark
+  redo            replay the last undone mark
+  solve           finish the puzzle with the solver
+  help            show this list
+  quit            leave
+`
+
+// runREPL lets the user solve b by hand, reading one command per line from in
+// and writing the board and any messages to out. The board is shown again
+// after every command. A mark that would repeat a value in its row or column,
+// or leave a clue impossible to satisfy, is refused with a message and not
+// applied. Returns when in runs out or the user quits, or with the error if
+// reading in fails.
+func runREPL(b *towers.Board, in io.Reader, out io.Writer) error {
+	fmt.Fprintf(out, "%s\n%s", b.PrettyString(), replHelp)
+	sc := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "> ")
+		if !sc.Scan() {
+			fmt.Fprintln(out)
+			return sc.Err()
+		}
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		cmd, args := fields[0], fields[1:]
+		if cmd == "quit" || cmd == "exit" {
+			return nil
+		}
+		if cmd == "help" {
+			fmt.Fprint(out, replHelp)
+			continue
+		}
+		msg, err := replCommand(b, cmd, args)
+		if err != nil {
+			fmt.Fprintf(out, "%v\n", err)
+			continue
+		}
+		if msg != "" {
+			fmt.Fprintln(out, msg)
+		}
+		fmt.Fprint(out, b.PrettyString())
+		if b.Solved() == nil {
+			fmt.Fprintln(out, "Solved!")
+		}
+	}
+}
+
+// replCommand runs a single REPL command other than help and quit, returning
+// a message to show the user, or an error if the command was refused.
+func replCommand(b *towers.Board, cmd string, args []string) (string, error) {
+	switch cmd {
+	case "mark":
+		n, err := replInts(b, args, 3)
+		if err != nil {
+			return "", err
+		}
+		if err := checkMove(b, n[0], n[1], n[2]); err != nil {
+			return "", err
+		}
+		b.Mark(n[0], n[1], n[2])
+		return "", nil
+	case "candidates":
+		n, err := replInts(b, args, 2)
+		if err != nil {
+			return "", err
+		}
+		if v := b.Get(n[0], n[1]); v != towers.EMPTY {
+			return fmt.Sprintf("(%d, %d) holds %d", n[0], n[1], v), nil
+		}
+		vals := b.Candidates(n[0], n[1])
+		strs := make([]string, len(vals))
+		for i, v := range vals {
+			strs[i] = strconv.Itoa(v)
+		}
+		return fmt.Sprintf("(%d, %d) allows %s", n[0], n[1], strings.Join(strs, " ")), nil
+	case "hint":
+		s, err := b.Hint()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s: %s", s.Technique, s.Description), nil
+	case "undo":
+		return "", b.Undo()
+	case "redo":
+		return "", b.Redo()
+	case "solve":
+		if res := b.Solve(); res.Err != nil {
+			return "", res.Err
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("unknown command %q; type help for a list", cmd)
+}
+
+// replInts parses args as exactly n integers: a row and a column, each between
+// 0 and Size-1, optionally followed by a value between 1 and Size.
+func replInts(b *towers.Board, args []string, n int) ([]int, error) {
+	if len(args) != n {
+		return nil, fmt.Errorf("expected %d numbers, got %d", n, len(args))
+	}
+	out := make([]int, n)
+	for i, a := range args {
+		v, err := strconv.Atoi(a)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", a)
+		}
+		lo, hi := 0, b.Size-1
+		if i == 2 {
+			lo, hi = 1, b.Size
+		}
+		if v < lo || v > hi {
+			return nil, fmt.Errorf("%d is out of range; must be between %d and %d", v, lo, hi)
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+// checkMove returns an error explaining why v can't go at row ri, col ci: the
+// cell is already filled, v is already in the row or column, or the row's or
+// column's clues could no longer be met with v in place, as judged by
+// PermFitsObsPartial. Returns nil if the move is allowed.
+func checkMove(b *towers.Board, ri, ci, v int) error {
+	if old := b.Get(ri, ci); old != towers.EMPTY {
+		return fmt.Errorf("(%d, %d) already holds %d; undo to change it", ri, ci, old)
+	}
+	row := make([]int, b.Size)
+	col := make([]int, b.Size)
+	for i := 0; i < b.Size; i++ {
+		row[i] = b.Get(ri, i)
+		col[i] = b.Get(i, ci)
+		if row[i] == v {
+			return fmt.Errorf("row %d already has %d at (%d, %d)", ri, v, ri, i)
+		}
+		if col[i] == v {
+			return fmt.Errorf("col %d already has %d at (%d, %d)", ci, v, i, ci)
+		}
+	}
+	row[ci] = v
+	col[ri] = v
+	if fwd, bwd := b.ObserversFor(towers.OBS_ROW, ri); !towers.PermFitsObsPartial(row, fwd, bwd) {
+		return fmt.Errorf("%d at (%d, %d) breaks a clue on row %d", v, ri, ci, ri)
+	}
+	if fwd, bwd := b.ObserversFor(towers.OBS_COL, ci); !towers.PermFitsObsPartial(col, fwd, bwd) {
+		return fmt.Errorf("%d at (%d, %d) breaks a clue on col %d", v, ri, ci, ci)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package towers
+
+// CompactPerms shrinks Perms to just the permutations still referenced by
+// some line, remapping RowPerms, ColPerms and the lists saved for Pop to
+// match. Kept permutations stay in their original relative order, so every
+// list stays ascending. After heavy trimming this frees most of the memory
+// Perms takes up on a long-lived board. Solving carries on as before; code
+// that needs every permutation again, such as recomputing a line after a clue
+// changes, restores the full Perms first. Clones taken earlier keep the old
+// Perms. Does nothing if Perms hasn't been generated.
+func (b *Board) CompactPerms() {
+	if b.Perms == nil {
+		return
+	}
+	used := make([]bool, len(b.Perms))
+	b.eachPermList(func(list *[]int) *[]int {
+		for _, pi := range *list {
+			used[pi] = true
+		}
+		return list
+	})
+	remap := make([]int, len(b.Perms))
+	perms := make([][]int, 0)
+	for pi, ok := range used {
+		if ok {
+			remap[pi] = len(perms)
+			perms = append(perms, b.Perms[pi])
+		}
+	}
+	if len(perms) == len(b.Perms) {
+		return
+	}
+	b.remapPermLists(remap)
+	b.Perms = perms
+}
+
+// permsCompacted reports whether Perms has been shrunk by CompactPerms, and
+// so no longer holds every permutation in PermuteN order.
+func (b *Board) permsCompacted() bool {
+	return b.Perms != nil && len(b.Perms) != fact(b.Size)
+}
+
+// fullPerms returns Perms if it holds every permutation, or a freshly
+// generated PermuteN(Size) if it has been compacted.
+func (b *Board) fullPerms() [][]int {
+	if b.permsCompacted() {
+		return PermuteN(b.Size)
+	}
+	return b.Perms
+}
+
+// expandPerms undoes CompactPerms, regenerating the full Perms and remapping
+// every list to the indexes of the same permutations in it. Does nothing if
+// Perms hasn't been compacted.
+func (b *Board) expandPerms() {
+	if !b.permsCompacted() {
+		return
+	}
+	remap := make([]int, len(b.Perms))
+	for pi, p := range b.Perms {
+		remap[pi] = permRank(p)
+	}
+	b.remapPermLists(remap)
+	b.Perms = PermuteN(b.Size)
+}
+
+// remapPermLists replaces every permutation list on the board, including the
+// ones saved for Pop, with a copy in which each index pi becomes remap[pi].
+// Lists are copied rather than edited, since clones may share them, and a list
+// shared by several lines or trail entries is copied once. The reverse index
+// is dropped, as it describes the old indexes.
+func (b *Board) remapPermLists(remap []int) {
+	done := make(map[*[]int]*[]int)
+	b.eachPermList(func(list *[]int) *[]int {
+		if out, ok := done[list]; ok {
+			return out
+		}
+		out := make([]int, len(*list))
+		for i, pi := range *list {
+			out[i] = remap[pi]
+		}
+		done[list] = &out
+		return &out
+	})
+	b.permIndex = nil
+}
+
+// eachPermList calls f with every non-nil permutation list in RowPerms,
+// ColPerms and the trail, replacing each with the list f returns.
+func (b *Board) eachPermList(f func(*[]int) *[]int) {
+	for i := 0; i < b.Size; i++ {
+		if b.RowPerms[i] != nil {
+			b.RowPerms[i] = f(b.RowPerms[i])
+		}
+		if b.ColPerms[i] != nil {
+			b.ColPerms[i] = f(b.ColPerms[i])
+		}
+	}
+	for i := range b.trail {
+		if b.trail[i].kind == trailPerms && b.trail[i].perms != nil {
+			b.trail[i].perms = f(b.trail[i].perms)
+		}
+	}
+}
+
+// permRank returns the index of permutation p of 1 to len(p) in
+// PermuteN(len(p)), which lists them in lexicographic order.
+func permRank(p []int) int {
+	n := len(p)
+	used := make([]bool, n+1)
+	rank := 0
+	for i, v := range p {
+		smaller := 0
+		for u := 1; u < v; u++ {
+			if !used[u] {
+				smaller++
+			}
+		}
+		rank += smaller * fact(n-1-i)
+		used[v] = true
+	}
+	return rank
+}
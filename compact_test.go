@@ -0,0 +1,63 @@
+package towers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSolveAfterCompactPerms(t *testing.T) {
+	for _, name := range append(bundledPuzzles, "hardPuzzle8") {
+		var puzzle *Board
+		if name == "hardPuzzle8" {
+			puzzle = hardBoard8(t)
+		} else {
+			puzzle = loadPuzzle(t, name)
+			puzzle.MarkMandatory()
+			puzzle.TrimPermsFromAllowed()
+		}
+		if puzzle.NumEmpty == 0 {
+			continue
+		}
+		want := puzzle.Clone()
+		if res := want.Solve(); res.Err != nil {
+			t.Fatalf("%s: %v", name, res.Err)
+		}
+
+		b := puzzle.Clone()
+		before := len(b.Perms)
+		b.CompactPerms()
+		if len(b.Perms) >= before {
+			t.Errorf("%s: CompactPerms left %d of %d perms", name, len(b.Perms), before)
+		}
+		for i := 0; i < b.Size; i++ {
+			for _, typ := range []int{OBS_ROW, OBS_COL} {
+				if got, want := b.LinePerms(typ, i), puzzle.LinePerms(typ, i); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s: line %d/%d has %d perms after compacting, %d before", name, typ, i, len(got), len(want))
+				}
+			}
+		}
+		if res := b.Solve(); res.Err != nil {
+			t.Fatalf("%s: solving after CompactPerms: %v", name, res.Err)
+		}
+		if !b.Equals(want) {
+			t.Errorf("%s: solved after CompactPerms to\n%s\nwant\n%s", name, b, want)
+		}
+
+		// Compacting inside a speculative frame must survive the Pop.
+		b = puzzle.Clone()
+		ri, ci := b.branchCell()
+		b.Push()
+		b.Mark(ri, ci, b.Candidates(ri, ci)[0])
+		b.propagate()
+		b.CompactPerms()
+		if err := b.Pop(); err != nil {
+			t.Fatalf("%s: Pop: %v", name, err)
+		}
+		if res := b.Solve(); res.Err != nil {
+			t.Fatalf("%s: solving after compacting in a frame: %v", name, res.Err)
+		}
+		if !b.Equals(want) {
+			t.Errorf("%s: solved after compacting in a frame to\n%s\nwant\n%s", name, b, want)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package towers
+
+// DebugInvariants turns on a consistency check after every Mark and Unset,
+// which panics as soon as NumEmpty no longer matches the number of empty cells
+// in Grid or a filled cell allows a value other than its own. The check scans
+// the whole board, so it is off by default and meant for tracking down bugs,
+// not for normal runs. It should only be changed while no solving is in
+// progress.
+var DebugInvariants bool
+
+// checkInvariants panics if NumEmpty or the filled cells' Allowed lists are
+// out of step with Grid. Unlike CheckConsistency, it doesn't require the
+// values in filled cells to be gone from the cells around them, since Set
+// alone doesn't remove them.
+func (b *Board) checkInvariants() {
+	if err := b.checkCells(false); err != nil {
+		panic("towers: board invariant broken: " + err.Error())
+	}
+}
@@ -0,0 +1,186 @@
+package towers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Constant literals used while building a CNF formula. A clause containing
+// cnfTrue is dropped, and cnfFalse is dropped from any clause containing it,
+// which keeps the edge cases of the visibility encoding out of the main
+// loops.
+const (
+	cnfTrue  = int(^uint(0) >> 1)
+	cnfFalse = -cnfTrue
+)
+
+// cnf accumulates the variables and clauses of a formula in DIMACS form.
+type cnf struct {
+	numVars int
+	clauses [][]int
+}
+
+// newVar allocates a fresh variable and returns its positive literal.
+func (f *cnf) newVar() int {
+	f.numVars++
+	return f.numVars
+}
+
+// add appends the clause made of lits, after simplifying away constants.
+func (f *cnf) add(lits ...int) {
+	clause := make([]int, 0, len(lits))
+	for _, l := range lits {
+		switch l {
+		case cnfTrue:
+			return
+		case cnfFalse:
+			continue
+		}
+		clause = append(clause, l)
+	}
+	f.clauses = append(f.clauses, clause)
+}
+
+// ToDIMACS writes the puzzle to w as a SAT problem in DIMACS CNF format.
+// Variable r*Size*Size + c*Size + v is true iff the cell at row r, col c
+// (both counted from 0) holds value v; the variables after those are
+// auxiliary. The clauses require every cell to hold exactly one value, every
+// row and column to hold each value exactly once, every filled cell to keep
+// its value and every observer to see exactly its count. Allowed is not
+// encoded, so the formula describes the puzzle as given rather than the
+// solver's progress on it.
+//
+// Visibility is encoded per observer with two families of auxiliary
+// variables. above(i, v) is true iff some tower in front of position i, as
+// seen by the observer, is at least v tall, so the tower at position i is
+// visible iff above(i, v+1) is false for its height v. A sequential counter
+// then tracks how many of the first i towers are visible, and the count at
+// the end of the line is pinned to the observer's Count.
+func (b *Board) ToDIMACS(w io.Writer) error {
+	n := b.Size
+	f := &cnf{numVars: n * n * n}
+	x := func(ri, ci, v int) int {
+		return ri*n*n + ci*n + v
+	}
+	for ri := 0; ri < n; ri++ {
+		for ci := 0; ci < n; ci++ {
+			cell := make([]int, 0, n)
+			for v := 1; v <= n; v++ {
+				cell = append(cell, x(ri, ci, v))
+				for u := v + 1; u <= n; u++ {
+					f.add(-x(ri, ci, v), -x(ri, ci, u))
+				}
+			}
+			f.add(cell...)
+			if val := b.Get(ri, ci); val != EMPTY {
+				f.add(x(ri, ci, val))
+			}
+		}
+	}
+	for i := 0; i < n; i++ {
+		for v := 1; v <= n; v++ {
+			row := make([]int, 0, n)
+			col := make([]int, 0, n)
+			for j := 0; j < n; j++ {
+				row = append(row, x(i, j, v))
+				col = append(col, x(j, i, v))
+				for k := j + 1; k < n; k++ {
+					f.add(-x(i, j, v), -x(i, k, v))
+					f.add(-x(j, i, v), -x(k, i, v))
+				}
+			}
+			f.add(row...)
+			f.add(col...)
+		}
+	}
+	for _, o := range b.Observers {
+		b.encodeObserver(f, o, x)
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "c towers puzzle, size %d\n", n)
+	fmt.Fprintf(bw, "c variable r*%d + c*%d + v means cell (r, c) holds v\n", n*n, n)
+	fmt.Fprintf(bw, "p cnf %d %d\n", f.numVars, len(f.clauses))
+	for _, clause := range f.clauses {
+		for _, l := range clause {
+			fmt.Fprintf(bw, "%d ", l)
+		}
+		fmt.Fprintln(bw, "0")
+	}
+	return bw.Flush()
+}
+
+// encodeObserver adds the clauses requiring observer o to see exactly o.Count
+// towers. x maps a cell and value to its variable, as in ToDIMACS.
+func (b *Board) encodeObserver(f *cnf, o *Observer, x func(ri, ci, v int) int) {
+	n := b.Size
+	ri, ci, dr, dc := b.ObserverPath(o)
+	pos := func(i int) (int, int) {
+		return ri + dr*i, ci + dc*i
+	}
+
+	// above[i][v] is true iff one of the first i towers is at least v tall.
+	// Heights above n can never occur, and nothing is in front of the first
+	// tower.
+	above := make([][]int, n)
+	for i := range above {
+		above[i] = make([]int, n+2)
+		for v := range above[i] {
+			above[i][v] = cnfFalse
+			if i > 0 && v >= 2 && v <= n {
+				above[i][v] = f.newVar()
+			}
+		}
+	}
+	for i := 1; i < n; i++ {
+		pr, pc := pos(i - 1)
+		for v := n; v >= 2; v-- {
+			// above[i][v] <=> above[i][v+1] || x(i-1, v) || above[i-1][v]
+			y, taller, here, before := above[i][v], above[i][v+1], x(pr, pc, v), above[i-1][v]
+			f.add(-y, taller, here, before)
+			f.add(y, -taller)
+			f.add(y, -here)
+			f.add(y, -before)
+		}
+	}
+
+	vis := make([]int, n)
+	for i := 0; i < n; i++ {
+		vis[i] = f.newVar()
+		pr, pc := pos(i)
+		for v := 1; v <= n; v++ {
+			f.add(-x(pr, pc, v), above[i][v+1], vis[i])
+			f.add(-x(pr, pc, v), -above[i][v+1], -vis[i])
+		}
+	}
+
+	// atLeast[j] is true iff at least j of the towers so far are visible.
+	// Only counts up to o.Count+1 matter.
+	top := o.Count + 1
+	atLeast := make([]int, top+1)
+	atLeast[0] = cnfTrue
+	for j := 1; j <= top; j++ {
+		atLeast[j] = cnfFalse
+	}
+	for i := 0; i < n; i++ {
+		next := make([]int, top+1)
+		next[0] = cnfTrue
+		for j := 1; j <= top; j++ {
+			// next[j] <=> atLeast[j] || (atLeast[j-1] && vis[i])
+			if j > i+1 {
+				next[j] = cnfFalse
+				continue
+			}
+			y := f.newVar()
+			next[j] = y
+			f.add(-y, atLeast[j], atLeast[j-1])
+			f.add(-y, atLeast[j], vis[i])
+			f.add(y, -atLeast[j])
+			f.add(y, -atLeast[j-1], -vis[i])
+		}
+		atLeast = next
+	}
+	f.add(atLeast[o.Count])
+	f.add(-atLeast[top])
+}
@@ -0,0 +1,51 @@
+package towers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrAlreadySolved = errors.New("puzzle is already solved")
+	ErrNeedsGuessing = errors.New("no logical deduction available; puzzle requires guessing")
+	ErrUnsatisfiable = errors.New("puzzle has no solution")
+	ErrAmbiguous     = errors.New("puzzle has more than one solution")
+	ErrInternal      = errors.New("internal solver error")
+)
+
+// A Guess is one value tried by the backtracking search at a branch point.
+type Guess struct {
+	Row   int
+	Col   int
+	Value int
+}
+
+func (g Guess) String() string {
+	return fmt.Sprintf("(%d, %d)=%d", g.Row, g.Col, g.Value)
+}
+
+// A ContradictionError is returned by a search that found no solution when
+// SolveOptions.TraceContradictions is set. Path lists the guesses along the
+// deepest branch the search explored, in the order they were made, and Err is
+// the contradiction that branch ran into. It unwraps to Err, which wraps
+// ErrUnsatisfiable.
+type ContradictionError struct {
+	Path []Guess
+	Err  error
+}
+
+func (e *ContradictionError) Error() string {
+	if len(e.Path) == 0 {
+		return e.Err.Error()
+	}
+	steps := make([]string, len(e.Path))
+	for i, g := range e.Path {
+		steps[i] = g.String()
+	}
+	return fmt.Sprintf("after guessing %s: %v", strings.Join(steps, ", "), e.Err)
+}
+
+func (e *ContradictionError) Unwrap() error {
+	return e.Err
+}
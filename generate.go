@@ -0,0 +1,218 @@
+package towers
+
+import "math/rand"
+
+// rebuild returns a fresh board of the same size as b with only the given
+// observers as clues and b's givens filled in. Perms is shared with b if it
+// has been generated, or regenerated in full if b's has been compacted.
+func (b *Board) rebuild(obs []*Observer) *Board {
+	c := NewBoard(b.Size)
+	for _, o := range obs {
+		c.SetEdgeClue(o.Type, o.Index, o.Direction, o.Count)
+	}
+	for ri := 0; ri < b.Size; ri++ {
+		for ci := 0; ci < b.Size; ci++ {
+			if b.Given[ri][ci] {
+				c.SetCell(ri, ci, b.Get(ri, ci))
+			}
+		}
+	}
+	c.History = nil
+	if b.Perms != nil {
+		c.Perms = b.fullPerms()
+		c.PopulateRowColPerms()
+		c.TrimAllowedFromPerms()
+	}
+	return c
+}
+
+// Minimize returns a new board with as few edge clues as possible that still
+// has a unique solution. Clues are tried one at a time in an order shuffled by
+// seed, and each is dropped if the puzzle stays unique without it, so the same
+// seed always gives the same result. Only the clues and the givens carry over
+// to the new board; cells filled in by the solver do not. If b does not have a
+// unique solution to begin with, no clues are removed.
+func (b *Board) Minimize(seed int64) *Board {
+	groups := make([][]*Observer, 0, len(b.Observers))
+	for _, o := range b.Observers {
+		groups = append(groups, []*Observer{o})
+	}
+	return b.minimizeGroups(rand.New(rand.NewSource(seed)), groups)
+}
+
+// minimizeGroups does the work of Minimize, removing whole groups of clues at
+// a time. The groups are tried in an order shuffled by rng, one pass over
+// each list in turn; members of a group that are already gone are ignored.
+// b itself is not modified.
+func (b *Board) minimizeGroups(rng *rand.Rand, passes ...[][]*Observer) *Board {
+	// The clone shares b's observers, so the groups still match them.
+	b = b.Clone()
+	b.InitPerms()
+	kept := append([]*Observer(nil), b.Observers...)
+	best := b.rebuild(kept)
+	if best.CountSolutions(2) != 1 {
+		return best
+	}
+	for _, groups := range passes {
+		order := append([][]*Observer(nil), groups...)
+		rng.Shuffle(len(order), func(i, j int) {
+			order[i], order[j] = order[j], order[i]
+		})
+		for _, g := range order {
+			trial := make([]*Observer, 0, len(kept))
+			for _, k := range kept {
+				if !observerIn(k, g) {
+					trial = append(trial, k)
+				}
+			}
+			if len(trial) == len(kept) {
+				continue
+			}
+			c := b.rebuild(trial)
+			if c.CountSolutions(2) == 1 {
+				kept = trial
+				best = c
+			}
+		}
+	}
+	return best
+}
+
+// observerIn returns true iff o is one of the observers in list.
+func observerIn(o *Observer, list []*Observer) bool {
+	for _, p := range list {
+		if p == o {
+			return true
+		}
+	}
+	return false
+}
+
+// randomLatinSquare fills a size x size grid so that every row and column
+// holds each of 1..size exactly once. Cells are filled in row-major order,
+// trying values in an order shuffled by rng and backing up on a dead end.
+func randomLatinSquare(size int, rng *rand.Rand) [][]int {
+	g := make([][]int, size)
+	for ri := range g {
+		g[ri] = make([]int, size)
+	}
+	var fill func(pos int) bool
+	fill = func(pos int) bool {
+		if pos == size*size {
+			return true
+		}
+		ri, ci := pos/size, pos%size
+		for _, v := range rng.Perm(size) {
+			v++
+			used := false
+			for i := 0; i < size && !used; i++ {
+				used = (i < ci && g[ri][i] == v) || (i < ri && g[i][ci] == v)
+			}
+			if used {
+				continue
+			}
+			g[ri][ci] = v
+			if fill(pos + 1) {
+				return true
+			}
+		}
+		g[ri][ci] = EMPTY
+		return false
+	}
+	fill(0)
+	return g
+}
+
+// Generate returns a new puzzle of the given size along with the grid it was
+// built from. A random solution grid is chosen and every edge clue is read
+// off it; if those clues don't pin the grid down, another grid is tried. The
+// clues are then thinned out with Minimize, so the puzzle has no givens and
+// solution is its only solution. The same seed always gives the same puzzle.
+func Generate(size int, seed int64) (puzzle *Board, solution [][]int) {
+	rng := rand.New(rand.NewSource(seed))
+	full, solution := uniqueFullBoard(size, rng)
+	return full.Minimize(rng.Int63()), solution
+}
+
+// GenerateSymmetric is Generate, but the clues left in the puzzle form a
+// pattern with 180-degree rotational symmetry. Clues are removed four at a
+// time, a clue together with its images under quarter turns of the board,
+// and then in pairs of clues opposite each other, wherever the puzzle stays
+// unique. The cost is that the puzzle may keep a few more clues than Generate
+// would.
+//
+// Unlike Minimize with a symmetry constraint in general, this never has to
+// give up symmetry to keep the solution unique, so there is nothing to relax
+// or report. The search starts from the full set of clues, which is
+// symmetric and, by the choice of solution grid, has a unique solution. Each
+// group removed is a union of opposite pairs, so every set of clues it tries
+// is symmetric, and a removal is only kept if the solution stays unique. In
+// the worst case no group can go and the full clue set is returned.
+func GenerateSymmetric(size int, seed int64) (puzzle *Board, solution [][]int) {
+	rng := rand.New(rand.NewSource(seed))
+	full, solution := uniqueFullBoard(size, rng)
+	byKey := make(map[Observer]*Observer)
+	for _, o := range full.Observers {
+		byKey[Observer{Type: o.Type, Index: o.Index, Direction: o.Direction}] = o
+	}
+	// turn gives the clue position a quarter turn clockwise away, as in
+	// Rotate90.
+	turn := func(o *Observer) *Observer {
+		k := Observer{Type: OBS_COL, Index: size - 1 - o.Index, Direction: o.Direction}
+		if o.Type == OBS_COL {
+			k = Observer{Type: OBS_ROW, Index: o.Index, Direction: 1 - o.Direction}
+		}
+		return byKey[k]
+	}
+	quads := make([][]*Observer, 0, size)
+	pairs := make([][]*Observer, 0, size*2)
+	for _, o := range full.Observers {
+		if o.Type == OBS_ROW && o.Direction == OBS_FWD {
+			o1 := turn(o)
+			o2 := turn(o1)
+			quads = append(quads, []*Observer{o, o1, o2, turn(o2)})
+		}
+		if o.Direction == OBS_FWD {
+			pairs = append(pairs, []*Observer{o, turn(turn(o))})
+		}
+	}
+	return full.minimizeGroups(rand.New(rand.NewSource(rng.Int63())), quads, pairs), solution
+}
+
+// uniqueFullBoard picks random solution grids until it finds one whose full
+// set of edge clues has no other solution, and returns the clued board along
+// with the grid.
+func uniqueFullBoard(size int, rng *rand.Rand) (*Board, [][]int) {
+	for {
+		solution := randomLatinSquare(size, rng)
+		full := boardFromSolution(solution)
+		if full.CountSolutions(2) == 1 {
+			return full, solution
+		}
+	}
+}
+
+// boardFromSolution returns an empty board carrying every edge clue that the
+// filled grid g would show.
+func boardFromSolution(g [][]int) *Board {
+	size := len(g)
+	b := NewBoard(size)
+	line := make([]int, size)
+	for i := 0; i < size; i++ {
+		for j := 0; j < size; j++ {
+			line[j] = g[i][j]
+		}
+		fwd, _ := visibleRange(line, false)
+		bwd, _ := visibleRange(line, true)
+		b.SetEdgeClue(OBS_ROW, i, OBS_FWD, fwd)
+		b.SetEdgeClue(OBS_ROW, i, OBS_BWD, bwd)
+		for j := 0; j < size; j++ {
+			line[j] = g[j][i]
+		}
+		fwd, _ = visibleRange(line, false)
+		bwd, _ = visibleRange(line, true)
+		b.SetEdgeClue(OBS_COL, i, OBS_FWD, fwd)
+		b.SetEdgeClue(OBS_COL, i, OBS_BWD, bwd)
+	}
+	return b
+}
@@ -0,0 +1,64 @@
+package towers
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestGenerateSolveRoundTrip generates seeded puzzles of sizes 4 to 7 and
+// checks that each has exactly one solution, that Solve finds it, and that it
+// is the grid the generator started from. Size 7 takes several seconds and
+// is skipped with -short.
+func TestGenerateSolveRoundTrip(t *testing.T) {
+	for size := 4; size <= 7; size++ {
+		for seed := int64(1); seed <= 3; seed++ {
+			t.Run(fmt.Sprintf("size%d/seed%d", size, seed), func(t *testing.T) {
+				if size == 7 && testing.Short() {
+					t.Skip("size 7 is slow")
+				}
+				puzzle, want := Generate(size, seed)
+				if n := puzzle.Clone().CountSolutions(2); n != 1 {
+					t.Fatalf("puzzle has %d solutions, want 1:\n%s", n, puzzle.ToPuzzleString())
+				}
+				b := puzzle.Clone()
+				if res := b.Solve(); res.Err != nil {
+					t.Fatalf("Solve: %v\n%s", res.Err, puzzle.ToPuzzleString())
+				}
+				if err := b.Solved(); err != nil {
+					t.Fatalf("Solved: %v\n%s", err, b)
+				}
+				if got := b.CopyGrid(); FormatGrid(got) != FormatGrid(want) {
+					t.Fatalf("solved grid differs from the generator's:\n%s\nwant\n%s", FormatGrid(got), FormatGrid(want))
+				}
+			})
+		}
+	}
+}
+
+func TestGenerateSymmetricIsSymmetricAndUnique(t *testing.T) {
+	for size := 2; size <= 6; size++ {
+		for seed := int64(1); seed <= 3; seed++ {
+			puzzle, want := GenerateSymmetric(size, seed)
+			if n := puzzle.Clone().CountSolutions(2); n != 1 {
+				t.Fatalf("size %d, seed %d: puzzle has %d solutions, want 1:\n%s", size, seed, n, puzzle.ToPuzzleString())
+			}
+			for _, o := range puzzle.Observers {
+				fwd, bwd := puzzle.ObserversFor(o.Type, size-1-o.Index)
+				opposite := fwd
+				if o.Direction == OBS_FWD {
+					opposite = bwd
+				}
+				if opposite == nil {
+					t.Errorf("size %d, seed %d: %s has no clue opposite it:\n%s", size, seed, o, puzzle.ToPuzzleString())
+				}
+			}
+			b := puzzle.Clone()
+			if err := b.BruteSolve(); err != nil {
+				t.Fatalf("size %d, seed %d: %v", size, seed, err)
+			}
+			if got := b.CopyGrid(); FormatGrid(got) != FormatGrid(want) {
+				t.Errorf("size %d, seed %d: solved grid differs from the generator's", size, seed)
+			}
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package towers
+
+import (
+	"context"
+	"errors"
+)
+
+// A Heuristic is one solving technique run by AutoSolve. Apply runs the
+// technique once and returns true iff it changed the board. Count, if set, is
+// used instead of Apply and returns how many candidates or permutations were
+// removed, which AutoSolve logs. Check, if set, is used instead of either by
+// techniques that can prove the board has no solution: it returns true iff it
+// changed the board, or an error saying why the board can't be solved, which
+// AutoSolve returns as it is. A Fallback heuristic only runs in a round
+// where none of the heuristics before it made any progress, which keeps the
+// expensive techniques from running while cheap ones still work. Perms marks
+// heuristics that work from the row and column permutation lists.
+type Heuristic struct {
+	Name     string
+	Apply    func(*Board) bool
+	Count    func(*Board) int
+	Check    func(*Board) (bool, error)
+	Fallback bool
+	Perms    bool
+}
+
+// run runs the heuristic once on b and returns the number of changes it
+// reports. Heuristics without a Count report 1 for any change. The error is
+// the one Check returned, if any.
+func (h Heuristic) run(b *Board) (int, error) {
+	if h.Count != nil {
+		return h.Count(b), nil
+	}
+	changed := false
+	var err error
+	if h.Check != nil {
+		changed, err = h.Check(b)
+	} else {
+		changed = h.Apply(b)
+	}
+	if changed {
+		return 1, err
+	}
+	return 0, err
+}
+
+// DefaultHeuristics returns the techniques AutoSolve uses when a board's
+// Heuristics list is nil and its Perms have been generated, in the order they
+// run.
+func DefaultHeuristics() []Heuristic {
+	return defaultHeuristics(0)
+}
+
+// LowMemoryHeuristics returns the techniques AutoSolve uses when a board's
+// Heuristics list is nil and the board has no Perms, because NoPerms is set or
+// the board is larger than MaxPermSize. None of them need Perms. The naked set
+// and found group searches grow steeply with the set size on large boards, so
+// they stop at lowMemoryMaxSet cells.
+func LowMemoryHeuristics() []Heuristic {
+	return lowMemoryHeuristics(0)
+}
+
+// lowMemoryMaxSet is the largest set LowMemoryHeuristics searches for.
+const lowMemoryMaxSet = 3
+
+// lowMemoryHeuristics is LowMemoryHeuristics with the set searches limited to
+// maxSet cells, or lowMemoryMaxSet if maxSet is 0 or larger.
+func lowMemoryHeuristics(maxSet int) []Heuristic {
+	if maxSet == 0 || maxSet > lowMemoryMaxSet {
+		maxSet = lowMemoryMaxSet
+	}
+	return []Heuristic{
+		{Name: "ApplyTrivialLines", Check: (*Board).applyTrivialLines},
+		{Name: "MarkMandatory", Apply: (*Board).MarkMandatory},
+		{Name: "MarkHiddenSingles", Apply: (*Board).MarkHiddenSingles},
+		{Name: "TrimByVisibility", Apply: (*Board).TrimByVisibility},
+		{Name: "TrimByDualObserver", Apply: (*Board).TrimByDualObserver, Fallback: true},
+		{Name: "TrimNakedSets", Count: trimSets((*Board).TrimNakedSetsCount, maxSet), Fallback: true},
+		{Name: "TrimFoundGroups", Count: trimSets((*Board).TrimFoundGroupsCount, maxSet), Fallback: true},
+		{Name: "TrimByTrial", Apply: (*Board).TrimByTrial, Fallback: true},
+	}
+}
+
+// defaultHeuristics returns DefaultHeuristics with the naked set and found
+// group searches limited to sets of at most maxSet cells. A maxSet of 0 means
+// no limit.
+func defaultHeuristics(maxSet int) []Heuristic {
+	return []Heuristic{
+		{Name: "ApplyTrivialLines", Check: (*Board).applyTrivialLines},
+		{Name: "MarkMandatory", Apply: (*Board).MarkMandatory},
+		{Name: "TrimByVisibility", Apply: (*Board).TrimByVisibility},
+		{Name: "TrimAllowedFromPerms", Count: (*Board).TrimAllowedFromPermsCount, Perms: true},
+		{Name: "TrimPermsFromAllowed", Count: (*Board).TrimPermsFromAllowedCount, Perms: true},
+		{Name: "TrimByPermIntersection", Apply: (*Board).TrimByPermIntersection, Perms: true},
+		{Name: "TrimXWing", Apply: (*Board).TrimXWing, Perms: true},
+		{Name: "TrimByDualObserver", Apply: (*Board).TrimByDualObserver, Fallback: true},
+		{Name: "TrimNakedSets", Count: trimSets((*Board).TrimNakedSetsCount, maxSet), Fallback: true},
+		{Name: "TrimFoundGroups", Count: trimSets((*Board).TrimFoundGroupsCount, maxSet), Fallback: true},
+		{Name: "TrimByTrial", Apply: (*Board).TrimByTrial, Fallback: true},
+	}
+}
+
+// trimSets wraps a set-based heuristic taking a set size n so that it tries
+// n = 2 through Size-2 (or maxSet, if smaller and nonzero) in turn, stopping
+// at the first size that removes anything.
+func trimSets(f func(*Board, int) int, maxSet int) func(*Board) int {
+	return func(b *Board) int {
+		for n := 2; n < b.Size-1 && (maxSet == 0 || n <= maxSet) && !b.timeUp(); n++ {
+			if removed := f(b, n); removed > 0 {
+				return removed
+			}
+		}
+		return 0
+	}
+}
+
+// SolveOptions controls how AutoSolveOpts goes about solving a board.
+type SolveOptions struct {
+	// AllowGuessing lets the solver fall back on backtracking search once
+	// the heuristics stop making progress.
+	AllowGuessing bool
+	// MaxSetSize limits the naked set and found group searches to sets of
+	// at most this many cells. 0 means the board's own MaxSetSize applies.
+	MaxSetSize int
+	// SkipPerms leaves out the heuristics that work from the permutation
+	// lists, which are the most expensive ones.
+	SkipPerms bool
+	// TraceContradictions makes a failed search return a
+	// *ContradictionError listing the guesses along the deepest branch it
+	// explored, to help work out why a puzzle has no solution.
+	TraceContradictions bool
+	// Parallel, if greater than 1, lets the backtracking search explore the
+	// branches of its first guess in up to this many goroutines, each on
+	// its own copy of the board. The solution found is the same one the
+	// single-threaded search would find. 0 or 1 searches on one goroutine.
+	Parallel int
+}
+
+// heuristics returns the list of heuristics to run on b under opts. The
+// board's own Heuristics are used if set, in which case MaxSetSize has no
+// effect.
+func (opts SolveOptions) heuristics(b *Board) []Heuristic {
+	maxSet := opts.MaxSetSize
+	if maxSet == 0 {
+		maxSet = b.MaxSetSize
+	}
+	all := b.Heuristics
+	if all == nil && b.Perms == nil {
+		all = lowMemoryHeuristics(maxSet)
+	} else if all == nil {
+		all = defaultHeuristics(maxSet)
+	}
+	out := make([]Heuristic, 0, len(all))
+	for _, h := range all {
+		if opts.SkipPerms && h.Perms {
+			continue
+		}
+		out = append(out, h)
+	}
+	return out
+}
+
+// AutoSolveOpts is AutoSolve with the set of techniques chosen by opts. If the
+// heuristics get stuck and opts.AllowGuessing is false, ErrNeedsGuessing is
+// returned and the board is left as far as logic got it; otherwise the
+// solution is finished with BruteSolve. Any other error the heuristics stop
+// with, such as a contradiction wrapping ErrUnsatisfiable, ErrAmbiguous for a
+// board with no clues or ErrInternal, is returned as it is either way, as
+// AutoSolveContext does.
+func (b *Board) AutoSolveOpts(opts SolveOptions) error {
+	ctx := context.Background()
+	b.InitPerms()
+	err := b.autoSolveWith(ctx, nil, opts.heuristics(b))
+	if err == nil || errors.Is(err, ErrUnsatisfiable) || errors.Is(err, ErrAmbiguous) || errors.Is(err, ErrInternal) || ctx.Err() != nil {
+		return err
+	}
+	if !opts.AllowGuessing {
+		return ErrNeedsGuessing
+	}
+	if opts.Parallel > 1 {
+		return b.bruteSolveParallel(ctx, nil, opts.Parallel, opts.TraceContradictions)
+	}
+	return b.bruteSolve(ctx, nil, nil, opts.TraceContradictions)
+}
+
+// LogicalProgressPossible reports whether one pass of the board's heuristics
+// makes any progress, meaning a cell is placed or a candidate removed. The
+// pass runs on a clone, each heuristic once in AutoSolve's order, and stops as
+// soon as something changes, so it is far cheaper than a full solve. Trims
+// that only shorten the permutation lists don't count on their own, though
+// the heuristics after them see the shorter lists. If the board's Perms
+// haven't been generated yet, the candidates InitPerms removes count as
+// progress too. A generator can use this to reject puzzles that need guessing
+// from the very first step.
+func (b *Board) LogicalProgressPossible() bool {
+	if b.NumEmpty == 0 {
+		return false
+	}
+	c := b.Clone()
+	c.Verbose = false
+	c.Reasons = nil
+	c.Progress = nil
+	candidates := b.NumCandidates()
+	progress := func() bool {
+		return c.NumEmpty < b.NumEmpty || c.NumCandidates() < candidates
+	}
+	c.InitPerms()
+	if progress() {
+		return true
+	}
+	for _, h := range c.activeHeuristics() {
+		h.run(c)
+		if progress() {
+			return true
+		}
+	}
+	return false
+}
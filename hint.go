@@ -0,0 +1,272 @@
+package towers
+
+import "fmt"
+
+// A SolveStep describes a single deduction. If Value is nonzero, the step
+// places Value at (Row, Col); otherwise it removes the candidates listed in
+// Elims. Technique names the heuristic that found the step, and Description
+// is a human-readable explanation.
+type SolveStep struct {
+	Technique   string
+	Row         int
+	Col         int
+	Value       int
+	Elims       []Elim
+	Description string
+}
+
+// Hint finds the next logical deduction without applying it. Techniques are
+// tried from cheapest to most expensive: mandatory marks, clue bounds, hidden
+// singles, naked sets and finally line permutations. Returns ErrAlreadySolved
+// if the board is solved and ErrNeedsGuessing if no technique applies.
+func (b *Board) Hint() (*SolveStep, error) {
+	if b.Solved() == nil {
+		return nil, ErrAlreadySolved
+	}
+	finders := []func() *SolveStep{
+		b.hintMandatory,
+		b.hintClueBounds,
+		b.hintHiddenSingle,
+		b.hintNakedSet,
+		b.hintLinePerms,
+	}
+	for _, f := range finders {
+		if s := f(); s != nil {
+			return s, nil
+		}
+	}
+	return nil, ErrNeedsGuessing
+}
+
+// ApplyStep performs the placement or eliminations described by s.
+func (b *Board) ApplyStep(s *SolveStep) {
+	if s.Value != EMPTY {
+		b.Mark(s.Row, s.Col, s.Value)
+		return
+	}
+	b.because(s.Technique, "%s", s.Description)
+	for _, e := range s.Elims {
+		b.disallow(e.Row, e.Col, e.Val)
+		b.noteElim(e.Row, e.Col, e.Val)
+	}
+}
+
+// hintMandatory finds an empty cell with exactly one allowed value.
+func (b *Board) hintMandatory() *SolveStep {
+	for ri := 0; ri < b.Size; ri++ {
+		for ci := 0; ci < b.Size; ci++ {
+			if b.Get(ri, ci) != EMPTY || b.Allowed[ri][ci].Len() != 1 {
+				continue
+			}
+			for n := 1; n <= b.Size; n++ {
+				if b.IsAllowed(ri, ci, n) {
+					return &SolveStep{
+						Technique:   "mandatory",
+						Row:         ri,
+						Col:         ci,
+						Value:       n,
+						Description: fmt.Sprintf("cell (%d, %d) can only hold %d", ri, ci, n),
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// hintClueBounds applies the distance bound for each observer: a tower at
+// distance d from an observer that sees c towers can be at most
+// Size - c + 1 + d tall, since at least c-1 shorter towers must be visible in
+// front of the tallest one.
+func (b *Board) hintClueBounds() *SolveStep {
+	for _, o := range b.Observers {
+		ri, ci, dr, dc := b.ObserverPath(o)
+		elims := make([]Elim, 0)
+		for d := 0; d < b.Size; d++ {
+			limit := b.Size - o.Count + 1 + d
+			if b.Get(ri, ci) == EMPTY {
+				for n := limit + 1; n <= b.Size; n++ {
+					if b.IsAllowed(ri, ci, n) {
+						elims = append(elims, Elim{ri, ci, n})
+					}
+				}
+			}
+			ri += dr
+			ci += dc
+		}
+		if len(elims) > 0 {
+			return &SolveStep{
+				Technique:   "clue bounds",
+				Elims:       elims,
+				Description: fmt.Sprintf("observer %s limits the heights near it", o),
+			}
+		}
+	}
+	return nil
+}
+
+// OnlyHomeFor looks for the single position along row or column index
+// (depending on typ) where value can go: a cell already holding it, or an
+// empty cell that still allows it. Returns ok = false if there are none or
+// several. The board is not modified.
+func (b *Board) OnlyHomeFor(typ, index, value int) (pos int, ok bool) {
+	pos = -1
+	for i := 0; i < b.Size; i++ {
+		ri, ci := index, i
+		if typ == OBS_COL {
+			ri, ci = i, index
+		}
+		val := b.Get(ri, ci)
+		if val != value && (val != EMPTY || !b.IsAllowed(ri, ci, value)) {
+			continue
+		}
+		if pos >= 0 {
+			return 0, false
+		}
+		pos = i
+	}
+	if pos < 0 {
+		return 0, false
+	}
+	return pos, true
+}
+
+// hintHiddenSingle finds a value that is allowed in only one empty cell of a
+// row or column.
+func (b *Board) hintHiddenSingle() *SolveStep {
+	for t := OBS_ROW; t <= OBS_COL; t++ {
+		for idx := 0; idx < b.Size; idx++ {
+			for n := 1; n <= b.Size; n++ {
+				pos, ok := b.OnlyHomeFor(t, idx, n)
+				if !ok {
+					continue
+				}
+				hr, hc := idx, pos
+				line := "row"
+				if t == OBS_COL {
+					hr, hc = pos, idx
+					line = "col"
+				}
+				if b.Get(hr, hc) != EMPTY {
+					continue
+				}
+				return &SolveStep{
+					Technique:   "hidden single",
+					Row:         hr,
+					Col:         hc,
+					Value:       n,
+					Description: fmt.Sprintf("in %s %d, only cell (%d, %d) can hold %d", line, idx, hr, hc, n),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// hintNakedSet finds a naked set whose values can be removed from at least one
+// other cell in the same line.
+func (b *Board) hintNakedSet() *SolveStep {
+	for n := 2; n < b.Size-1; n++ {
+		indices := Permute(0, b.Size-1, n)
+		for idx := 0; idx < b.Size; idx++ {
+			for _, idxs := range indices {
+				elims := make([]Elim, 0)
+				if b.CheckRowNakedSet(idxs, idx) {
+					for ci := 0; ci < b.Size; ci++ {
+						if SliceContains(idxs, ci) {
+							continue
+						}
+						for v := 1; v <= b.Size; v++ {
+							if b.IsAllowed(idx, idxs[0], v) && b.IsAllowed(idx, ci, v) {
+								elims = append(elims, Elim{idx, ci, v})
+							}
+						}
+					}
+					if len(elims) > 0 {
+						return &SolveStep{
+							Technique:   "naked set",
+							Elims:       elims,
+							Description: fmt.Sprintf("row %d has a naked set at cols %v", idx, idxs),
+						}
+					}
+				}
+				if b.CheckColumnNakedSet(idxs, idx) {
+					for ri := 0; ri < b.Size; ri++ {
+						if SliceContains(idxs, ri) {
+							continue
+						}
+						for v := 1; v <= b.Size; v++ {
+							if b.IsAllowed(idxs[0], idx, v) && b.IsAllowed(ri, idx, v) {
+								elims = append(elims, Elim{ri, idx, v})
+							}
+						}
+					}
+					if len(elims) > 0 {
+						return &SolveStep{
+							Technique:   "naked set",
+							Elims:       elims,
+							Description: fmt.Sprintf("col %d has a naked set at rows %v", idx, idxs),
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// hintLinePerms finds candidates that do not appear at their position in any
+// remaining permutation for the cell's row or column.
+func (b *Board) hintLinePerms() *SolveStep {
+	for ri := 0; ri < b.Size; ri++ {
+		for ci := 0; ci < b.Size; ci++ {
+			if b.Get(ri, ci) != EMPTY {
+				continue
+			}
+			for n := 1; n <= b.Size; n++ {
+				if !b.IsAllowed(ri, ci, n) {
+					continue
+				}
+				if b.RowPerms[ri] != nil && !b.permsHave(OBS_ROW, ri, *b.RowPerms[ri], ci, n) {
+					return &SolveStep{
+						Technique:   "line permutations",
+						Elims:       []Elim{{ri, ci, n}},
+						Description: fmt.Sprintf("no arrangement of row %d puts %d at col %d", ri, n, ci),
+					}
+				}
+				if b.ColPerms[ci] != nil && !b.permsHave(OBS_COL, ci, *b.ColPerms[ci], ri, n) {
+					return &SolveStep{
+						Technique:   "line permutations",
+						Elims:       []Elim{{ri, ci, n}},
+						Description: fmt.Sprintf("no arrangement of col %d puts %d at row %d", ci, n, ri),
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// permsHave returns true iff any of the permutations indexed by perms has n at
+// position pos and is consistent with the Allowed lists of line index of type
+// t.
+func (b *Board) permsHave(t, index int, perms []int, pos, n int) bool {
+	for _, pi := range perms {
+		p := b.Perms[pi]
+		if p[pos] != n {
+			continue
+		}
+		fits := true
+		for i := 0; i < b.Size && fits; i++ {
+			if t == OBS_ROW {
+				fits = b.IsAllowed(index, i, p[i])
+			} else {
+				fits = b.IsAllowed(i, index, p[i])
+			}
+		}
+		if fits {
+			return true
+		}
+	}
+	return false
+}
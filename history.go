@@ -0,0 +1,170 @@
+package towers
+
+import "fmt"
+
+// An Elim identifies a single value removed from the Allowed list of the cell
+// at row Row, col Col.
+type Elim struct {
+	Row int
+	Col int
+	Val int
+}
+
+// A MarkRecord describes everything changed by one call to Mark: the cell's
+// previous and new values and every Allowed entry that was deleted as a
+// result.
+type MarkRecord struct {
+	Row     int
+	Col     int
+	Old     int
+	New     int
+	Removed []Elim
+}
+
+// Undo reverses the most recent Mark, restoring the grid value, NumEmpty and
+// every Allowed entry that the mark removed. The record is moved onto the redo
+// stack. Returns an error if there is nothing to undo.
+func (b *Board) Undo() error {
+	if len(b.History) == 0 {
+		return fmt.Errorf("nothing to undo")
+	}
+	rec := b.History[len(b.History)-1]
+	b.History = b.History[:len(b.History)-1]
+	b.Set(rec.Row, rec.Col, rec.Old)
+	for _, e := range rec.Removed {
+		b.Allowed[e.Row][e.Col].Add(e.Val)
+	}
+	b.Undone = append(b.Undone, rec)
+	return nil
+}
+
+// Redo replays the most recently undone Mark. Returns an error if there is
+// nothing to redo.
+func (b *Board) Redo() error {
+	if len(b.Undone) == 0 {
+		return fmt.Errorf("nothing to redo")
+	}
+	rec := b.Undone[len(b.Undone)-1]
+	undone := b.Undone[:len(b.Undone)-1]
+	b.Mark(rec.Row, rec.Col, rec.New)
+	b.Undone = undone
+	return nil
+}
+
+// A Placement describes one filled cell: its position, its value and whether
+// it was given in the puzzle rather than filled in while solving.
+type Placement struct {
+	Row   int
+	Col   int
+	Value int
+	Given bool
+}
+
+// Placements lists every filled cell in the order the board was built: the
+// givens in row-major order, then the solved cells in the order History
+// recorded their Marks. Cells filled without a History record, such as after
+// a Pop or on a loaded checkpoint, come last in row-major order.
+func (b *Board) Placements() []Placement {
+	out := make([]Placement, 0, b.Size*b.Size-b.NumEmpty)
+	done := make([][]bool, b.Size)
+	for ri := 0; ri < b.Size; ri++ {
+		done[ri] = make([]bool, b.Size)
+		for ci := 0; ci < b.Size; ci++ {
+			if b.Given[ri][ci] && b.Get(ri, ci) != EMPTY {
+				out = append(out, Placement{ri, ci, b.Get(ri, ci), true})
+				done[ri][ci] = true
+			}
+		}
+	}
+	for _, rec := range b.History {
+		if done[rec.Row][rec.Col] || rec.New == EMPTY || b.Get(rec.Row, rec.Col) != rec.New {
+			continue
+		}
+		out = append(out, Placement{rec.Row, rec.Col, rec.New, false})
+		done[rec.Row][rec.Col] = true
+	}
+	for ri := 0; ri < b.Size; ri++ {
+		for ci := 0; ci < b.Size; ci++ {
+			if !done[ri][ci] && b.Get(ri, ci) != EMPTY {
+				out = append(out, Placement{ri, ci, b.Get(ri, ci), false})
+			}
+		}
+	}
+	return out
+}
+
+// Kinds of trailEntry.
+const (
+	trailCell = iota
+	trailCandidate
+	trailPerms
+)
+
+// A trailEntry records one change made while a Push is in effect. For
+// trailCell, val is the old value of the cell at row, col; for trailCandidate,
+// val is the candidate removed from it. For trailPerms, row is the line number
+// and perms its old permutation list.
+type trailEntry struct {
+	kind  int
+	row   int
+	col   int
+	val   int
+	perms *[]int
+}
+
+// A pushFrame marks the state of the trail and the undo stacks at a Push.
+type pushFrame struct {
+	trail   int
+	history int
+	undone  []*MarkRecord
+}
+
+// record appends e to the trail if a Push is in effect.
+func (b *Board) record(e trailEntry) {
+	if len(b.frames) > 0 {
+		b.trail = append(b.trail, e)
+	}
+}
+
+// Push starts recording every change made to the grid, the Allowed lists and
+// the permutation lists, so that the next Pop can reverse them. Pushes nest.
+// This lets a search try a guess in place rather than on a clone. Undo and
+// Redo must not be called between a Push and its Pop, and Reasons recorded in
+// between are kept.
+func (b *Board) Push() {
+	b.frames = append(b.frames, pushFrame{
+		trail:   len(b.trail),
+		history: len(b.History),
+		undone:  b.Undone,
+	})
+}
+
+// Pop reverses every change made since the matching Push, including the
+// History entries added by Mark. Returns an error if there is no Push to
+// match.
+func (b *Board) Pop() error {
+	if len(b.frames) == 0 {
+		return fmt.Errorf("nothing to pop")
+	}
+	f := b.frames[len(b.frames)-1]
+	b.frames = b.frames[:len(b.frames)-1]
+	for i := len(b.trail) - 1; i >= f.trail; i-- {
+		e := b.trail[i]
+		switch e.kind {
+		case trailCell:
+			b.setGrid(e.row, e.col, e.val)
+		case trailCandidate:
+			b.Allowed[e.row][e.col].Add(e.val)
+		case trailPerms:
+			if e.row < b.Size {
+				b.RowPerms[e.row] = e.perms
+			} else {
+				b.ColPerms[e.row-b.Size] = e.perms
+			}
+		}
+	}
+	b.trail = b.trail[:f.trail]
+	b.History = b.History[:f.history]
+	b.Undone = f.undone
+	return nil
+}
@@ -0,0 +1,121 @@
+package towers
+
+import (
+	"context"
+	"sync"
+)
+
+// bruteSolveParallel is bruteSolve with the branches of the search's first
+// guess explored by up to workers goroutines at once, each on its own clone
+// of the board. When a branch finds a solution, the branches after it in guess
+// order are canceled, but the ones before it run to the end, and the solution
+// kept is the one from the earliest branch that has one. That is the solution
+// the single-threaded search would have found, so the result doesn't depend on
+// which goroutine finishes first. With trace set, the deepest contradiction
+// over all branches is reported, the earliest branch winning ties.
+func (b *Board) bruteSolveParallel(ctx context.Context, stats *SolveStats, workers int, trace bool) error {
+	if ri, ci, ok := b.FindContradiction(); ok {
+		return noCandidatesError(ri, ci)
+	}
+	b.InitPerms()
+	root := b.Clone()
+	if err := root.propagate(); err != nil {
+		if !trace {
+			err = nil
+		}
+		return b.finishSearch(ctx, nil, nil, err)
+	}
+	if root.NumEmpty == 0 {
+		err := root.Solved()
+		if err == nil {
+			return b.finishSearch(ctx, root, nil, nil)
+		}
+		if !trace {
+			err = nil
+		}
+		return b.finishSearch(ctx, nil, nil, err)
+	}
+	ri, ci := root.branchCell()
+	vals := root.guessOrder(ri, ci)
+
+	// Each branch gets its own context, so a solution can cancel just the
+	// branches after it.
+	type branch struct {
+		ctx    context.Context
+		cancel context.CancelFunc
+		s      searcher
+		sol    *Board
+	}
+	branches := make([]*branch, len(vals))
+	for i := range branches {
+		bctx, cancel := context.WithCancel(ctx)
+		branches[i] = &branch{ctx: bctx, cancel: cancel}
+	}
+	var mu sync.Mutex
+	found := len(vals)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(vals); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				br := branches[i]
+				guess := Guess{Row: ri, Col: ci, Value: vals[i]}
+				br.s = searcher{
+					ctx: br.ctx,
+					visit: func(s *Board) bool {
+						br.sol = s.Clone()
+						mu.Lock()
+						if i < found {
+							found = i
+							for _, later := range branches[i+1:] {
+								later.cancel()
+							}
+						}
+						mu.Unlock()
+						return false
+					},
+					trace: trace,
+				}
+				if trace {
+					br.s.path = []Guess{guess}
+				}
+				c := root.Clone()
+				c.Mark(ri, ci, vals[i])
+				br.s.guesses++
+				br.s.search(c)
+			}
+		}()
+	}
+	for i := range vals {
+		mu.Lock()
+		skip := i > found
+		mu.Unlock()
+		if skip || ctx.Err() != nil {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var sol *Board
+	var deepest []Guess
+	var deepestErr error
+	guesses := 0
+	for _, br := range branches {
+		br.cancel()
+		guesses += br.s.guesses
+		if sol == nil && br.sol != nil {
+			sol = br.sol
+		}
+		if br.s.deepestErr != nil && (deepestErr == nil || len(br.s.deepest) > len(deepest)) {
+			deepest, deepestErr = br.s.deepest, br.s.deepestErr
+		}
+	}
+	if stats != nil {
+		stats.Guesses += guesses
+	}
+	return b.finishSearch(ctx, sol, deepest, deepestErr)
+}
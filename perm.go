@@ -1,4 +1,9 @@
-package main
+package towers
+
+import (
+	"math"
+	"strconv"
+)
 
 // permuter is a struct that manages state for the recursive permutation
 // function.
@@ -8,9 +13,11 @@ type permuter struct {
 	Lowest int
 	Seq    []int
 	Used   []bool
+	Yield  func([]int) bool
 }
 
-// fact returns n! for any nonnegative input n.
+// fact returns n! for any nonnegative input n. The result overflows for n >
+// 20 on 64-bit platforms; use permCount where that matters.
 func fact(n int) int {
 	if n <= 1 {
 		return 1
@@ -21,20 +28,88 @@ func fact(n int) int {
 	return n * fact(n-1)
 }
 
+// maxPrealloc caps the capacity Permute reserves up front. Larger outputs
+// still work; they just grow the slice as they go.
+const maxPrealloc = 1 << 20
+
+// permCount returns n!/(n-r)!, the number of r-permutations of n items. The
+// second return value is false if the result would overflow an int.
+func permCount(n, r int) (int, bool) {
+	out := 1
+	for i := 0; i < r; i++ {
+		f := n - i
+		if f <= 0 {
+			return 0, true
+		}
+		if out > math.MaxInt/f {
+			return 0, false
+		}
+		out *= f
+	}
+	return out, true
+}
+
+// EstimatePermMemory returns the number of permutations InitPerms generates
+// for a board of the given size, which is size!, and an estimate of the bytes
+// they take up in Perms: a slice header plus size ints for each one. The
+// RowPerms and ColPerms index lists are not counted. If size! would overflow
+// an int, both results are the largest values their types can hold.
+func EstimatePermMemory(size int) (count int, bytes int64) {
+	count, ok := permCount(size, size)
+	if !ok {
+		return math.MaxInt, math.MaxInt64
+	}
+	word := int64(strconv.IntSize / 8)
+	per := (3 + int64(size)) * word
+	if int64(count) > math.MaxInt64/per {
+		return count, math.MaxInt64
+	}
+	return count, int64(count) * per
+}
+
 // Permute is the main public permutation API function. Returns all slices of
-// r integers between low and high *inclusive*.
+// r integers between low and high *inclusive*. If r is 0, the result is a
+// single empty slice; if r is negative or more than the number of integers
+// available, the result is empty.
 func Permute(low, high, r int) [][]int {
 	popSize := (high - low) + 1
-	out := make([][]int, 0, fact(popSize)/(fact(popSize-r)))
+	if r < 0 || r > popSize {
+		return [][]int{}
+	}
+	capacity, ok := permCount(popSize, r)
+	if !ok || capacity > maxPrealloc {
+		capacity = maxPrealloc
+	}
+	out := make([][]int, 0, capacity)
+	PermuteFunc(low, high, r, func(seq []int) bool {
+		tmp := make([]int, len(seq))
+		copy(tmp, seq)
+		out = append(out, tmp)
+		return true
+	})
+	return out
+}
+
+// PermuteFunc generates the same permutations as Permute, in the same order,
+// but passes each one to yield instead of storing it, so memory use does not
+// grow with the number of permutations. The slice passed to yield is reused
+// between calls and must be copied if the caller wants to keep it. Generation
+// stops early if yield returns false. yield is never called if r is negative
+// or more than the number of integers available.
+func PermuteFunc(low, high, r int, yield func([]int) bool) {
+	popSize := (high - low) + 1
+	if r < 0 || r > popSize {
+		return
+	}
 	p := permuter{
 		N:      popSize,
 		Lowest: low,
 		R:      r,
 		Seq:    make([]int, r),
 		Used:   make([]bool, popSize),
+		Yield:  yield,
 	}
-	p.permute(0, &out)
-	return out
+	p.permute(0)
 }
 
 // NPermuteR is a helper function that returns Permute(1, n, r).
@@ -47,13 +122,11 @@ func PermuteN(n int) [][]int {
 	return Permute(1, n, n)
 }
 
-// Permute is the main recursive permutation function.
-func (p *permuter) permute(depth int, output *[][]int) {
+// permute is the main recursive permutation function. Returns false if the
+// yield function asked to stop.
+func (p *permuter) permute(depth int) bool {
 	if depth == p.R {
-		tmp := make([]int, len(p.Seq))
-		copy(tmp, p.Seq)
-		*output = append(*output, tmp)
-		return
+		return p.Yield(p.Seq)
 	}
 	for i := 0; i < p.N; i++ {
 		if p.Used[i] {
@@ -61,8 +134,136 @@ func (p *permuter) permute(depth int, output *[][]int) {
 		}
 		p.Seq[depth] = i + p.Lowest
 		p.Used[i] = true
-		p.permute(depth+1, output)
+		ok := p.permute(depth + 1)
 		p.Seq[depth] = 0
 		p.Used[i] = false
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// obsPermuter generates the permutations of 1 to N, or prefixes of them,
+// that can fit a pair of observers, pruning each prefix whose bounds on the
+// visible counts already rule out the clues.
+type obsPermuter struct {
+	N     int
+	R     int
+	Fwd   *Observer
+	Bwd   *Observer
+	Seq   []int
+	Used  []bool
+	Fact  []int
+	Yield func(idx int, p []int) bool
+}
+
+// permuteFitting calls yield with each permutation of 1 to n that fits both
+// observers, in the order PermuteN returns them, along with its index in
+// PermuteN(n). Nil observers are ignored. As with PermuteFunc, the slice is
+// reused between calls, and generation stops early if yield returns false.
+//
+// If r is less than n, yield gets prefixes of length r instead, along with
+// the index of the first permutation starting with each one. The prefixes
+// passed have not been ruled out, but the (n-r)! permutations starting with
+// each one still need to be checked with PermFitsObs.
+func permuteFitting(n, r int, fwd, bwd *Observer, yield func(idx int, p []int) bool) {
+	if r > n {
+		r = n
+	}
+	p := obsPermuter{
+		N:     n,
+		R:     r,
+		Fwd:   fwd,
+		Bwd:   bwd,
+		Seq:   make([]int, n),
+		Used:  make([]bool, n+1),
+		Fact:  make([]int, n+1),
+		Yield: yield,
+	}
+	for i := 0; i <= n; i++ {
+		p.Fact[i] = fact(i)
+	}
+	p.permute(0, 0, 0, -1, 0)
+}
+
+// permute extends the prefix Seq[:depth], in which vis towers are visible
+// from the front, the tallest is highest and the tallest possible tower sits
+// at top, or -1 if it hasn't been placed. base is the PermuteN index of the
+// first permutation starting with the prefix. Returns false if the yield
+// function asked to stop.
+func (p *obsPermuter) permute(depth, vis, highest, top, base int) bool {
+	if depth == p.N {
+		if !PermFitsObs(p.Seq, p.Fwd, p.Bwd) {
+			return true
+		}
+		return p.Yield(base, p.Seq)
+	}
+	if depth == p.R {
+		return p.Yield(base, p.Seq[:depth])
+	}
+	rank := 0
+	for v := 1; v <= p.N; v++ {
+		if p.Used[v] {
+			continue
+		}
+		idx := base + rank*p.Fact[p.N-1-depth]
+		rank++
+		nvis, nhighest, ntop := vis, highest, top
+		if v > highest {
+			nvis++
+			nhighest = v
+		}
+		if v == p.N {
+			ntop = depth
+		}
+		p.Seq[depth] = v
+		p.Used[v] = true
+		ok := true
+		if p.possible(depth+1, nvis, nhighest, ntop) {
+			ok = p.permute(depth+1, nvis, nhighest, ntop, idx)
+		}
+		p.Seq[depth] = 0
+		p.Used[v] = false
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// possible reports whether a prefix of length depth, described as in
+// permute, could still be completed to fit the observers. From the front,
+// every unused tower taller than highest might still be seen, and at least one
+// of them must be. From the back, nothing in front of the tallest tower is
+// visible, and something behind it always is.
+func (p *obsPermuter) possible(depth, vis, highest, top int) bool {
+	if p.Fwd != nil {
+		taller := 0
+		for v := highest + 1; v <= p.N; v++ {
+			if !p.Used[v] {
+				taller++
+			}
+		}
+		lo := vis
+		if taller > 0 {
+			lo++
+		}
+		if p.Fwd.Count < lo || p.Fwd.Count > vis+taller {
+			return false
+		}
+	}
+	if p.Bwd != nil {
+		pos := top
+		if pos < 0 {
+			pos = depth
+		}
+		if p.Bwd.Count > p.N-pos {
+			return false
+		}
+		if top >= 0 && top < p.N-1 && p.Bwd.Count < 2 {
+			return false
+		}
 	}
+	return true
 }
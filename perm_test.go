@@ -0,0 +1,52 @@
+package towers
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPermCountOverflow(t *testing.T) {
+	if got, ok := permCount(20, 20); !ok || got != 2432902008176640000 {
+		t.Errorf("permCount(20, 20) = %d, %v, want 20!, true", got, ok)
+	}
+	if _, ok := permCount(21, 21); ok {
+		t.Error("permCount(21, 21) reported no overflow")
+	}
+	if got, ok := permCount(40, 2); !ok || got != 40*39 {
+		t.Errorf("permCount(40, 2) = %d, %v, want 1560, true", got, ok)
+	}
+	if count, bytes := EstimatePermMemory(21); count != math.MaxInt || bytes != math.MaxInt64 {
+		t.Errorf("EstimatePermMemory(21) = %d, %d, want the maximum values", count, bytes)
+	}
+	// 21! and 25! overflow, which made the old capacity computation go
+	// negative and the preallocation panic.
+	for _, c := range []struct{ high, r, want int }{{21, 1, 21}, {25, 2, 600}, {30, 3, 24360}} {
+		if got := len(Permute(1, c.high, c.r)); got != c.want {
+			t.Errorf("len(Permute(1, %d, %d)) = %d, want %d", c.high, c.r, got, c.want)
+		}
+	}
+}
+
+func TestPermuteBoundaries(t *testing.T) {
+	if got := Permute(1, 4, 0); len(got) != 1 || len(got[0]) != 0 {
+		t.Errorf("Permute(1, 4, 0) = %v, want a single empty slice", got)
+	}
+	if got := Permute(3, 2, 0); len(got) != 1 || len(got[0]) != 0 {
+		t.Errorf("Permute(3, 2, 0) = %v, want a single empty slice", got)
+	}
+	for _, c := range []struct{ low, high, r int }{{1, 4, -1}, {1, 4, 5}, {3, 2, 1}, {1, 1, 2}} {
+		if got := Permute(c.low, c.high, c.r); got == nil || len(got) != 0 {
+			t.Errorf("Permute(%d, %d, %d) = %v, want an empty result", c.low, c.high, c.r, got)
+		}
+		PermuteFunc(c.low, c.high, c.r, func(seq []int) bool {
+			t.Errorf("PermuteFunc(%d, %d, %d) yielded %v", c.low, c.high, c.r, seq)
+			return true
+		})
+	}
+	if got := Permute(2, 4, 3); len(got) != 6 {
+		t.Errorf("Permute(2, 4, 3) has %d permutations, want 6", len(got))
+	}
+	if got := Permute(5, 5, 1); len(got) != 1 || len(got[0]) != 1 || got[0][0] != 5 {
+		t.Errorf("Permute(5, 5, 1) = %v, want [[5]]", got)
+	}
+}
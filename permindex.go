@@ -0,0 +1,117 @@
+package towers
+
+// A linePermIndex is a reverse index over one line's permutation list. For
+// each position and value it counts the permutations in the list that put
+// that value at that position, so whether a value is still achievable at a
+// position can be answered without scanning the list. perms and list record
+// the list the counts describe; since the trims always replace a list rather
+// than editing it, a different pointer or length means the counts are stale.
+type linePermIndex struct {
+	perms  *[]int
+	list   []int
+	counts []int
+}
+
+// linePermsFor returns the permutation list for line number line, where lines
+// 0 to Size-1 are rows and Size to 2*Size-1 are columns.
+func (b *Board) linePermsFor(line int) *[]int {
+	if line < b.Size {
+		return b.RowPerms[line]
+	}
+	return b.ColPerms[line-b.Size]
+}
+
+// permValueCounts returns the reverse index counts for line number line, with
+// the count for value v at position pos stored at pos*(Size+1)+v. Returns nil
+// if the line has no permutation list. The index is brought up to date first:
+// if the line's list has changed since it was last used, the counts are
+// adjusted for just the permutations that were removed or restored, falling
+// back to a full recount when that would be cheaper.
+func (b *Board) permValueCounts(line int) []int {
+	cur := b.linePermsFor(line)
+	if cur == nil {
+		return nil
+	}
+	if b.permIndex == nil {
+		b.permIndex = make([]linePermIndex, b.Size*2)
+	}
+	ix := &b.permIndex[line]
+	if ix.perms == cur && len(ix.list) == len(*cur) {
+		return ix.counts
+	}
+	diff := len(ix.list) - len(*cur)
+	if diff < 0 {
+		diff = -diff
+	}
+	if ix.counts == nil || diff > len(*cur) || !ix.update(b, *cur) {
+		ix.rebuild(b, *cur)
+	}
+	ix.perms = cur
+	ix.list = *cur
+	return ix.counts
+}
+
+// update adjusts the counts from ix.list to list by walking both in step,
+// since every list is kept in ascending order, and adding or removing the
+// permutations found in only one of them. Returns false, leaving the counts in
+// an unknown state, if either list turns out not to be ascending.
+func (ix *linePermIndex) update(b *Board, list []int) bool {
+	old := ix.list
+	i, j := 0, 0
+	for i < len(old) || j < len(list) {
+		if (i > 0 && i < len(old) && old[i] <= old[i-1]) || (j > 0 && j < len(list) && list[j] <= list[j-1]) {
+			return false
+		}
+		switch {
+		case j == len(list) || (i < len(old) && old[i] < list[j]):
+			ix.add(b, old[i], -1)
+			i++
+		case i == len(old) || list[j] < old[i]:
+			ix.add(b, list[j], 1)
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	return true
+}
+
+// rebuild recounts the index from scratch for list.
+func (ix *linePermIndex) rebuild(b *Board, list []int) {
+	ix.counts = make([]int, b.Size*(b.Size+1))
+	for _, pi := range list {
+		ix.add(b, pi, 1)
+	}
+}
+
+// add adds delta to the count of every value at its position in permutation
+// pi.
+func (ix *linePermIndex) add(b *Board, pi, delta int) {
+	for pos, v := range b.Perms[pi] {
+		ix.counts[pos*(b.Size+1)+v] += delta
+	}
+}
+
+// lineAllows reports whether some permutation in the list for line number line
+// puts value n at position pos. A line without a list allows everything.
+func (b *Board) lineAllows(line, pos, n int) bool {
+	counts := b.permValueCounts(line)
+	return counts == nil || counts[pos*(b.Size+1)+n] > 0
+}
+
+// clonePermIndex returns a deep copy of the reverse index, so a cloned board
+// can keep it up to date without disturbing the original's.
+func (b *Board) clonePermIndex() []linePermIndex {
+	if b.permIndex == nil {
+		return nil
+	}
+	out := make([]linePermIndex, len(b.permIndex))
+	for i, ix := range b.permIndex {
+		out[i] = ix
+		if ix.counts != nil {
+			out[i].counts = append([]int(nil), ix.counts...)
+		}
+	}
+	return out
+}
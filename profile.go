@@ -0,0 +1,22 @@
+package towers
+
+import "sync/atomic"
+
+// ProfilePermFitsObs turns on counting of PermFitsObs calls, for telling
+// whether permutation setup or the trims dominate a run. It is off by default,
+// leaving a single branch on the hot path, and should only be changed while
+// no solving is in progress.
+var ProfilePermFitsObs bool
+
+var permFitsObsCalls atomic.Int64
+
+// PermFitsObsCalls returns the number of PermFitsObs calls made while
+// ProfilePermFitsObs was set, since the last ResetPermFitsObsCalls.
+func PermFitsObsCalls() int64 {
+	return permFitsObsCalls.Load()
+}
+
+// ResetPermFitsObsCalls sets the PermFitsObs call count back to zero.
+func ResetPermFitsObsCalls() {
+	permFitsObsCalls.Store(0)
+}
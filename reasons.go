@@ -0,0 +1,59 @@
+package towers
+
+import "fmt"
+
+// A CellKey identifies a cell by row and column.
+type CellKey struct {
+	Row int
+	Col int
+}
+
+// An Elimination records why Value was removed from a cell's Allowed list:
+// the heuristic that removed it and a short description of what triggered it,
+// such as the line and indices of a naked set.
+type Elimination struct {
+	Value     int
+	Heuristic string
+	Trigger   string
+}
+
+// EnableReasonTracking starts recording an Elimination in Reasons each time a
+// heuristic, DisallowAll, DisallowOthers or ApplyStep removes a candidate. The
+// candidates a placement removes are recorded in History by Mark instead.
+// Tracking is off by default, and costs nothing until it is enabled.
+func (b *Board) EnableReasonTracking() {
+	if b.Reasons == nil {
+		b.Reasons = make(map[CellKey][]Elimination)
+	}
+}
+
+// ReasonsFor returns the recorded eliminations for the cell at row ri, col
+// ci, in the order they happened.
+func (b *Board) ReasonsFor(ri, ci int) []Elimination {
+	return b.Reasons[CellKey{ri, ci}]
+}
+
+// because sets the heuristic and trigger that will be recorded for the
+// following eliminations. The trigger is only formatted when tracking is
+// enabled.
+func (b *Board) because(heuristic, format string, args ...interface{}) {
+	if b.Reasons == nil {
+		return
+	}
+	b.reason = Elimination{
+		Heuristic: heuristic,
+		Trigger:   fmt.Sprintf(format, args...),
+	}
+}
+
+// noteElim records the removal of val from the cell at row ri, col ci using
+// the reason most recently set by because.
+func (b *Board) noteElim(ri, ci, val int) {
+	if b.Reasons == nil {
+		return
+	}
+	e := b.reason
+	e.Value = val
+	k := CellKey{ri, ci}
+	b.Reasons[k] = append(b.Reasons[k], e)
+}
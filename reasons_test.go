@@ -0,0 +1,60 @@
+package towers
+
+import "testing"
+
+func TestEveryEliminationHasAReason(t *testing.T) {
+	heuristics := append(DefaultHeuristics(), LowMemoryHeuristics()...)
+	for i, puzzle := range foundGroupBoards(t) {
+		for _, h := range heuristics {
+			b := puzzle.Clone()
+			b.EnableReasonTracking()
+			history := len(b.History)
+			h.run(b)
+			placed := map[Elim]bool{}
+			for _, rec := range b.History[history:] {
+				for _, e := range rec.Removed {
+					placed[e] = true
+				}
+			}
+			for ri := 0; ri < b.Size; ri++ {
+				for ci := 0; ci < b.Size; ci++ {
+					reasons := map[int]string{}
+					for _, e := range b.ReasonsFor(ri, ci) {
+						reasons[e.Value] = e.Heuristic
+					}
+					for _, v := range puzzle.Candidates(ri, ci) {
+						if b.IsAllowed(ri, ci, v) || placed[Elim{ri, ci, v}] {
+							continue
+						}
+						if name, ok := reasons[v]; !ok {
+							t.Errorf("board %d: %s removed %d from (%d, %d) without a reason", i, h.Name, v, ri, ci)
+						} else if name != h.Name {
+							t.Errorf("board %d: %s removed %d from (%d, %d), recorded as %s", i, h.Name, v, ri, ci, name)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestApplyStepRecordsReason(t *testing.T) {
+	b := loadPuzzle(t, "problem6.txt")
+	b.EnableReasonTracking()
+	for b.Solved() != nil {
+		s, err := b.Hint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.ApplyStep(s)
+		for _, e := range s.Elims {
+			found := false
+			for _, r := range b.ReasonsFor(e.Row, e.Col) {
+				found = found || (r.Value == e.Val && r.Heuristic == s.Technique && r.Trigger == s.Description)
+			}
+			if !found {
+				t.Fatalf("%s step removing %d from (%d, %d) left no reason", s.Technique, e.Val, e.Row, e.Col)
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package towers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PrettyString renders the board inside a box-drawing frame, with each cell in
+// a fixed-width column and the edge clues placed outside the frame. Empty
+// cells are shown as dots.
+func (b *Board) PrettyString() string {
+	var sb strings.Builder
+	border := func(left, mid, right string) {
+		sb.WriteString("  " + left)
+		for ci := 0; ci < b.Size; ci++ {
+			if ci > 0 {
+				sb.WriteString(mid)
+			}
+			sb.WriteString("───")
+		}
+		sb.WriteString(right + "\n")
+	}
+	clues := func(direction int) {
+		sb.WriteString("  ")
+		for ci := 0; ci < b.Size; ci++ {
+			sb.WriteString("  " + b.ObsChar(OBS_COL, ci, direction) + " ")
+		}
+		sb.WriteString("\n")
+	}
+	clues(OBS_FWD)
+	border("┌", "┬", "┐")
+	for ri := 0; ri < b.Size; ri++ {
+		if ri > 0 {
+			border("├", "┼", "┤")
+		}
+		sb.WriteString(b.ObsChar(OBS_ROW, ri, OBS_FWD) + " │")
+		for ci := 0; ci < b.Size; ci++ {
+			cell := "."
+			if b.Get(ri, ci) != EMPTY {
+				cell = b.CharAt(ri, ci)
+			}
+			sb.WriteString(" " + cell + " │")
+		}
+		sb.WriteString(" " + b.ObsChar(OBS_ROW, ri, OBS_BWD) + "\n")
+	}
+	border("└", "┴", "┘")
+	clues(OBS_BWD)
+	return sb.String()
+}
+
+// ANSI escape sequences used by ColorString.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiClue   = "\x1b[33m"
+	ansiGiven  = "\x1b[1;34m"
+	ansiPlaced = "\x1b[32m"
+)
+
+// ColorString renders the board in the same layout as String, but colors the
+// edge clues, the givens and the values placed by the solver differently
+// using ANSI escapes. If color is false, no escapes are emitted, which is
+// appropriate for output that isn't going to a terminal.
+func (b *Board) ColorString(color bool) string {
+	paint := func(code, s string) string {
+		if !color || code == "" || s == " " {
+			return s
+		}
+		return code + s + ansiReset
+	}
+	out := " "
+	for ci := 0; ci < b.Size; ci++ {
+		out += paint(ansiClue, b.ObsChar(OBS_COL, ci, OBS_FWD))
+	}
+	out += "\n"
+	for ri := 0; ri < b.Size; ri++ {
+		out += paint(ansiClue, b.ObsChar(OBS_ROW, ri, OBS_FWD))
+		for ci := 0; ci < b.Size; ci++ {
+			code := ansiPlaced
+			if b.Given[ri][ci] {
+				code = ansiGiven
+			}
+			if b.Get(ri, ci) == EMPTY {
+				code = ""
+			}
+			out += paint(code, b.CharAt(ri, ci))
+		}
+		out += paint(ansiClue, b.ObsChar(OBS_ROW, ri, OBS_BWD))
+		out += "\n"
+	}
+	out += " "
+	for ci := 0; ci < b.Size; ci++ {
+		out += paint(ansiClue, b.ObsChar(OBS_COL, ci, OBS_BWD))
+	}
+	return out
+}
+
+// PrintAllowed prints the candidates of every cell to stdout, one row at a
+// time, for debugging.
+func (b *Board) PrintAllowed() {
+	for ri := 0; ri < b.Size; ri++ {
+		fmt.Printf("Row %d\n", ri)
+		for ci := 0; ci < b.Size; ci++ {
+			fmt.Printf("%d: ", ci)
+			for _, k := range b.Candidates(ri, ci) {
+				fmt.Printf("%d ", k)
+			}
+			fmt.Printf("\n")
+		}
+	}
+}
+
+//TODO: inverse of naked sets
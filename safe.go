@@ -0,0 +1,58 @@
+package towers
+
+// The Board methods are not safe for concurrent use: queries read the same
+// maps that the solvers mutate. The Safe* methods below take the board's lock,
+// so any number of goroutines may call the query methods (SafeGet,
+// SafeIsAllowed, SafeSolved, SafeHint) at once, and the mutators (SafeMark,
+// SafeApplyStep, SafeAutoSolve) get exclusive access. Mixing Safe* calls with
+// unlocked calls from other goroutines is still a race.
+
+// SafeGet is Get under a read lock.
+func (b *Board) SafeGet(ri, ci int) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.Get(ri, ci)
+}
+
+// SafeIsAllowed is IsAllowed under a read lock.
+func (b *Board) SafeIsAllowed(ri, ci, n int) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.IsAllowed(ri, ci, n)
+}
+
+// SafeSolved is Solved under a read lock.
+func (b *Board) SafeSolved() error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.Solved()
+}
+
+// SafeHint is Hint under a read lock. Hint doesn't modify the board, so many
+// clients can ask for hints at the same time.
+func (b *Board) SafeHint() (*SolveStep, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.Hint()
+}
+
+// SafeMark is Mark under a write lock.
+func (b *Board) SafeMark(ri, ci, val int) (bool, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.Mark(ri, ci, val)
+}
+
+// SafeApplyStep is ApplyStep under a write lock.
+func (b *Board) SafeApplyStep(s *SolveStep) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ApplyStep(s)
+}
+
+// SafeAutoSolve is AutoSolve under a write lock.
+func (b *Board) SafeAutoSolve() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.AutoSolve()
+}
@@ -0,0 +1,50 @@
+package towers
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestSafeConcurrentUse has readers query a board while a writer solves it a
+// step at a time. It is meant to be run with -race.
+func TestSafeConcurrentUse(t *testing.T) {
+	b := loadPuzzle(t, "problem6.txt")
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				for ri := 0; ri < b.Size; ri++ {
+					for ci := 0; ci < b.Size; ci++ {
+						b.SafeGet(ri, ci)
+						b.SafeIsAllowed(ri, ci, r+1)
+					}
+				}
+				b.SafeSolved()
+				b.SafeHint()
+			}
+		}(r)
+	}
+	for steps := 0; b.SafeSolved() != nil; steps++ {
+		if steps > b.Size*b.Size*4 {
+			t.Fatal("the hints never solved the puzzle")
+		}
+		step, err := b.SafeHint()
+		if err != nil {
+			t.Fatalf("SafeHint: %v", err)
+		}
+		b.SafeApplyStep(step)
+	}
+	close(done)
+	wg.Wait()
+	if err := b.SafeSolved(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,74 @@
+//go:build !bitset
+
+package towers
+
+// Set is an unordered collection of distinct values. It's a map underneath,
+// but code outside this file sticks to the methods below, so the
+// representation can be changed without touching the solver; building with
+// -tags bitset swaps in the bitset in set_bitset.go.
+type Set[T comparable] map[T]struct{}
+
+// NewSet returns a set containing the given values.
+func NewSet[T comparable](vals ...T) Set[T] {
+	s := make(Set[T], len(vals))
+	for _, v := range vals {
+		s[v] = struct{}{}
+	}
+	return s
+}
+
+// Add inserts v into the set.
+func (s Set[T]) Add(v T) {
+	s[v] = struct{}{}
+}
+
+// Delete removes v from the set. Deleting a missing value is a no-op.
+func (s Set[T]) Delete(v T) {
+	delete(s, v)
+}
+
+// Has returns true iff v is in the set.
+func (s Set[T]) Has(v T) bool {
+	_, ok := s[v]
+	return ok
+}
+
+// Len returns the number of values in the set.
+func (s Set[T]) Len() int {
+	return len(s)
+}
+
+// Keys returns the set's values in no particular order; the order can differ
+// from one call to the next. Anything that picks values from a set one at a
+// time should sort them first, or step through the possible values in order
+// and test them with Has, so that runs are reproducible. For candidate sets,
+// Board.Candidates does the sorting.
+func (s Set[T]) Keys() []T {
+	out := make([]T, 0, len(s))
+	for v := range s {
+		out = append(out, v)
+	}
+	return out
+}
+
+// Equal returns true iff both sets contain exactly the same values.
+func (s Set[T]) Equal(other Set[T]) bool {
+	if len(s) != len(other) {
+		return false
+	}
+	for v := range s {
+		if !other.Has(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns a copy of the set.
+func (s Set[T]) Clone() Set[T] {
+	out := make(Set[T], len(s))
+	for v := range s {
+		out[v] = struct{}{}
+	}
+	return out
+}
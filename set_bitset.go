@@ -0,0 +1,63 @@
+//go:build bitset
+
+package towers
+
+import "math/bits"
+
+// Set is an unordered collection of distinct values from 0 to 63, stored as
+// the bits of a uint64. This is the version built with -tags bitset; set.go
+// has the map the solver uses by default. The bits sit behind a pointer so
+// that a copied Set refers to the same values, as a copied map would.
+type Set[T ~int] struct {
+	p *uint64
+}
+
+// NewSet returns a set containing the given values.
+func NewSet[T ~int](vals ...T) Set[T] {
+	s := Set[T]{p: new(uint64)}
+	for _, v := range vals {
+		s.Add(v)
+	}
+	return s
+}
+
+// Add inserts v into the set.
+func (s Set[T]) Add(v T) {
+	*s.p |= 1 << uint(v)
+}
+
+// Delete removes v from the set. Deleting a missing value is a no-op.
+func (s Set[T]) Delete(v T) {
+	*s.p &^= 1 << uint(v)
+}
+
+// Has returns true iff v is in the set.
+func (s Set[T]) Has(v T) bool {
+	return v >= 0 && v < 64 && *s.p&(1<<uint(v)) != 0
+}
+
+// Len returns the number of values in the set.
+func (s Set[T]) Len() int {
+	return bits.OnesCount64(*s.p)
+}
+
+// Keys returns the set's values. Unlike the map version they come out in
+// ascending order, but callers mustn't rely on that.
+func (s Set[T]) Keys() []T {
+	out := make([]T, 0, s.Len())
+	for w := *s.p; w != 0; w &= w - 1 {
+		out = append(out, T(bits.TrailingZeros64(w)))
+	}
+	return out
+}
+
+// Equal returns true iff both sets contain exactly the same values.
+func (s Set[T]) Equal(other Set[T]) bool {
+	return *s.p == *other.p
+}
+
+// Clone returns a copy of the set.
+func (s Set[T]) Clone() Set[T] {
+	w := *s.p
+	return Set[T]{p: &w}
+}
@@ -0,0 +1,68 @@
+package towers
+
+import (
+	"sort"
+	"testing"
+)
+
+func sortedKeys(s Set[int]) []int {
+	keys := s.Keys()
+	sort.Ints(keys)
+	return keys
+}
+
+func TestSet(t *testing.T) {
+	s := NewSet(3, 1, 3)
+	if s.Len() != 2 || !s.Has(1) || !s.Has(3) || s.Has(2) {
+		t.Fatalf("NewSet(3, 1, 3) = %v, want {1, 3}", sortedKeys(s))
+	}
+	s.Add(2)
+	s.Add(2)
+	if got := sortedKeys(s); len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("after adding 2, Keys = %v, want [1 2 3]", got)
+	}
+	s.Delete(1)
+	s.Delete(7)
+	if s.Len() != 2 || s.Has(1) {
+		t.Errorf("after deleting 1 and 7, set is %v, want {2, 3}", sortedKeys(s))
+	}
+	if !s.Equal(NewSet(3, 2)) || s.Equal(NewSet(2)) || s.Equal(NewSet(2, 4)) {
+		t.Error("Equal disagrees with the contents")
+	}
+	if e := NewSet[int](); e.Len() != 0 || len(e.Keys()) != 0 || !e.Equal(NewSet[int]()) {
+		t.Error("empty set is not empty")
+	}
+}
+
+func TestSetCloneAndCopy(t *testing.T) {
+	s := NewSet(1, 2)
+	c := s.Clone()
+	c.Add(3)
+	s.Delete(1)
+	if !s.Equal(NewSet(2)) || !c.Equal(NewSet(1, 2, 3)) {
+		t.Errorf("Clone shares state: s = %v, clone = %v", sortedKeys(s), sortedKeys(c))
+	}
+	// A plain copy refers to the same set, as a map would.
+	alias := s
+	alias.Add(5)
+	if !s.Has(5) {
+		t.Error("a copied Set doesn't share its values")
+	}
+}
+
+func TestNumSet(t *testing.T) {
+	for n := 0; n <= 9; n++ {
+		s := NumSet(n)
+		if s.Len() != n {
+			t.Errorf("NumSet(%d) has %d values", n, s.Len())
+		}
+		for v := 1; v <= n; v++ {
+			if !s.Has(v) {
+				t.Errorf("NumSet(%d) lacks %d", n, v)
+			}
+		}
+		if s.Has(0) || s.Has(n+1) {
+			t.Errorf("NumSet(%d) = %v", n, sortedKeys(s))
+		}
+	}
+}
@@ -1,15 +1,24 @@
-package main
+package towers
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"math/bits"
+	"time"
 )
 
 // TrimPermsFromAllowed removes entries in RowPerns and ColPerms that are not
 // possible because they would violate the Allowed maps. Returns true iff any
 // changes were made.
 func (b *Board) TrimPermsFromAllowed() bool {
-	changed := false
+	return b.TrimPermsFromAllowedCount() > 0
+}
+
+// TrimPermsFromAllowedCount does the work of TrimPermsFromAllowed, returning
+// the number of permutations removed.
+func (b *Board) TrimPermsFromAllowedCount() int {
+	removed := 0
 	for ri, rp := range b.RowPerms {
 		if rp == nil {
 			continue
@@ -28,9 +37,8 @@ func (b *Board) TrimPermsFromAllowed() bool {
 			}
 		}
 		if len(*rp) != len(newPerms) {
-			//fmt.Printf("Replacing row %d perms - %d -> %d\n", ri, len(*rp), len(newPerms))
-			b.RowPerms[ri] = &newPerms
-			changed = true
+			removed += len(*rp) - len(newPerms)
+			b.swapLinePerms(ri, &newPerms)
 		}
 	}
 	for ci, cp := range b.ColPerms {
@@ -51,12 +59,11 @@ func (b *Board) TrimPermsFromAllowed() bool {
 			}
 		}
 		if len(*cp) != len(newPerms) {
-			//fmt.Printf("Replacing col %d perms - %d -> %d\n", ci, len(*cp), len(newPerms))
-			b.ColPerms[ci] = &newPerms
-			changed = true
+			removed += len(*cp) - len(newPerms)
+			b.swapLinePerms(b.Size+ci, &newPerms)
 		}
 	}
-	return changed
+	return removed
 }
 
 // MarkMandatory searches for cells with only one entry in Allowed and marks
@@ -68,28 +75,86 @@ func (b *Board) TrimPermsFromAllowed() bool {
 // necessary.
 func (b *Board) MarkMandatory() bool {
 	changed := false
-	redo := false
-	for ri, row := range b.Allowed {
-		for ci, allowed := range row {
-			if len(allowed) != 1 || b.Get(ri, ci) != EMPTY {
-				continue
-			}
-			k := 0
-			for key, _ := range allowed {
-				k = key
-				break
+	for redo := true; redo; {
+		redo = false
+		for ri, row := range b.Allowed {
+			for ci, allowed := range row {
+				if allowed.Len() != 1 || b.Get(ri, ci) != EMPTY {
+					continue
+				}
+				k := 0
+				for n := 1; n <= b.Size && k == 0; n++ {
+					if allowed.Has(n) {
+						k = n
+					}
+				}
+				ch, nch := b.Mark(ri, ci, k)
+				if ch {
+					changed = true
+				}
+				if nch {
+					redo = true
+				}
 			}
-			ch, nch := b.Mark(ri, ci, k)
-			if ch {
-				changed = true
+		}
+	}
+	return changed
+}
+
+// ApplyTrivialLines marks the cells forced by the two extreme clue values. An
+// observer that sees Size towers must be looking at 1, 2, ..., Size in order,
+// and one that sees a single tower must have the tallest tower right in front
+// of it. Cells that are already filled are left alone, and so are cells that
+// no longer allow the value forced on them; AutoSolve reports those as
+// contradictions. Returns true iff a change was made.
+func (b *Board) ApplyTrivialLines() bool {
+	changed, _ := b.applyTrivialLines()
+	return changed
+}
+
+// applyTrivialLines does the work of ApplyTrivialLines. If a clue forces a
+// value on a cell that holds another value or no longer allows it, it stops
+// and returns an error naming the clue, which wraps ErrUnsatisfiable.
+func (b *Board) applyTrivialLines() (bool, error) {
+	changed := false
+	force := func(o *Observer, ri, ci, v int) error {
+		if got := b.Get(ri, ci); got == v {
+			return nil
+		} else if got != EMPTY || !b.IsAllowed(ri, ci, v) {
+			return fmt.Errorf("unsolvable: %s needs %d at (%d, %d): %w", o, v, ri, ci, ErrUnsatisfiable)
+		}
+		b.Mark(ri, ci, v)
+		changed = true
+		return nil
+	}
+	for _, o := range b.Observers {
+		ri, ci, dr, dc := b.ObserverPath(o)
+		switch o.Count {
+		case b.Size:
+			for v := 1; v <= b.Size; v++ {
+				if err := force(o, ri, ci, v); err != nil {
+					return changed, err
+				}
+				ri += dr
+				ci += dc
 			}
-			if nch {
-				redo = true
+		case 1:
+			if err := force(o, ri, ci, b.Size); err != nil {
+				return changed, err
 			}
 		}
 	}
-	if redo {
-		b.MarkMandatory()
+	return changed, nil
+}
+
+// MarkHiddenSingles looks for values that are allowed in only one empty cell
+// of a row or column and marks them there, repeating until there are none
+// left. Returns true iff a change was made.
+func (b *Board) MarkHiddenSingles() bool {
+	changed := false
+	for s := b.hintHiddenSingle(); s != nil; s = b.hintHiddenSingle() {
+		b.Mark(s.Row, s.Col, s.Value)
+		changed = true
 	}
 	return changed
 }
@@ -98,37 +163,152 @@ func (b *Board) MarkMandatory() bool {
 // if it is inconsistent with any cell's Allowed list. Returns true iff at
 // least one permutation was eliminated.
 func (b *Board) TrimAllowedFromPerms() bool {
-	changed := false
+	return b.TrimAllowedFromPermsCount() > 0
+}
+
+// TrimAllowedFromPermsCount does the work of TrimAllowedFromPerms, returning
+// the number of candidates removed. Each lookup goes through the lines'
+// reverse indexes rather than scanning RowPerms and ColPerms.
+func (b *Board) TrimAllowedFromPermsCount() int {
+	removed := 0
 	for ri := 0; ri < b.Size; ri++ {
 		for ci := 0; ci < b.Size; ci++ {
-			for n, _ := range b.Allowed[ri][ci] {
+			for n := 1; n <= b.Size; n++ {
+				if !b.IsAllowed(ri, ci, n) {
+					continue
+				}
 				//Is n allowed in slot ci in a perm for row ri?
-				found := false
-				if b.RowPerms[ri] != nil {
-					for _, permI := range *b.RowPerms[ri] {
-						if b.Perms[permI][ci] == n {
-							found = true
-							break
-						}
-					}
-					if !found {
-						delete(b.Allowed[ri][ci], n)
-						changed = true
-						continue
-					}
+				if !b.lineAllows(ri, ci, n) {
+					b.disallow(ri, ci, n)
+					b.because("TrimAllowedFromPerms", "row %d perms", ri)
+					b.noteElim(ri, ci, n)
+					removed++
+					continue
 				}
 				//Is n allowed in slot ri in a perm for col ci?
-				found = false
-				if b.ColPerms[ci] != nil {
-					for _, permI := range *b.ColPerms[ci] {
-						if b.Perms[permI][ri] == n {
-							found = true
-							break
-						}
+				if !b.lineAllows(b.Size+ci, ri, n) {
+					b.disallow(ri, ci, n)
+					b.because("TrimAllowedFromPerms", "col %d perms", ci)
+					b.noteElim(ri, ci, n)
+					removed++
+				}
+			}
+		}
+	}
+	return removed
+}
+
+// linePosValues returns, for each position in a line, the set of values that
+// appear at that position in some permutation from perms that is consistent
+// with every cell's Allowed list along the line. t and index identify the
+// line. Returns nil if perms is nil, meaning the line is unconstrained.
+func (b *Board) linePosValues(t, index int, perms *[]int) []Set[int] {
+	if perms == nil {
+		return nil
+	}
+	out := make([]Set[int], b.Size)
+	for i := range out {
+		out[i] = NewSet[int]()
+	}
+	for _, pi := range *perms {
+		p := b.Perms[pi]
+		fits := true
+		for i := 0; i < b.Size && fits; i++ {
+			if t == OBS_ROW {
+				fits = b.IsAllowed(index, i, p[i])
+			} else {
+				fits = b.IsAllowed(i, index, p[i])
+			}
+		}
+		if !fits {
+			continue
+		}
+		for i, v := range p {
+			out[i].Add(v)
+		}
+	}
+	return out
+}
+
+// TrimByPermIntersection removes each candidate that is not achievable at its
+// cell by both the row and the column. For cell (r, c), the row's achievable
+// values are those at position c of some RowPerms[r] permutation that fits
+// the Allowed lists of the whole row, and likewise for the column; only values
+// in both sets are kept. Returns true iff at least one candidate was removed.
+func (b *Board) TrimByPermIntersection() bool {
+	changed := false
+	rowVals := make([][]Set[int], b.Size)
+	colVals := make([][]Set[int], b.Size)
+	for i := 0; i < b.Size; i++ {
+		rowVals[i] = b.linePosValues(OBS_ROW, i, b.RowPerms[i])
+		colVals[i] = b.linePosValues(OBS_COL, i, b.ColPerms[i])
+	}
+	for ri := 0; ri < b.Size; ri++ {
+		for ci := 0; ci < b.Size; ci++ {
+			for n := 1; n <= b.Size; n++ {
+				if !b.IsAllowed(ri, ci, n) {
+					continue
+				}
+				inRow := rowVals[ri] == nil || rowVals[ri][ci].Has(n)
+				inCol := colVals[ci] == nil || colVals[ci][ri].Has(n)
+				if !inRow {
+					b.because("TrimByPermIntersection", "row %d perms", ri)
+				} else if !inCol {
+					b.because("TrimByPermIntersection", "col %d perms", ci)
+				} else {
+					continue
+				}
+				b.disallow(ri, ci, n)
+				b.noteElim(ri, ci, n)
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// TrimXWing looks, for each value v, for two rows in which v can only go in
+// the same two columns, judging by the rows' remaining permutations (or their
+// Allowed lists, for rows without any). Between them, those two rows must
+// then fill both columns with v, so v is removed from the other cells of the
+// two columns; TrimPermsFromAllowed then drops the permutations that placed
+// it there. The same is done with the roles of rows and columns swapped.
+// Returns true iff at least one candidate was removed.
+func (b *Board) TrimXWing() bool {
+	changed := false
+	for t := OBS_ROW; t <= OBS_COL; t++ {
+		cell := func(line, pos int) (int, int) {
+			if t == OBS_ROW {
+				return line, pos
+			}
+			return pos, line
+		}
+		for v := 1; v <= b.Size; v++ {
+			pos := make([][]int, b.Size)
+			for l := 0; l < b.Size; l++ {
+				pos[l] = b.valuePositions(t, l, v)
+			}
+			for l1 := 0; l1 < b.Size; l1++ {
+				if len(pos[l1]) != 2 {
+					continue
+				}
+				for l2 := l1 + 1; l2 < b.Size; l2++ {
+					if len(pos[l2]) != 2 || pos[l2][0] != pos[l1][0] || pos[l2][1] != pos[l1][1] {
+						continue
 					}
-					if !found {
-						delete(b.Allowed[ri][ci], n)
-						changed = true
+					b.because("TrimXWing", "%d in lines %d and %d at %v", v, l1, l2, pos[l1])
+					for l := 0; l < b.Size; l++ {
+						if l == l1 || l == l2 {
+							continue
+						}
+						for _, p := range pos[l1] {
+							ri, ci := cell(l, p)
+							if b.Get(ri, ci) == EMPTY && b.IsAllowed(ri, ci, v) {
+								b.disallow(ri, ci, v)
+								b.noteElim(ri, ci, v)
+								changed = true
+							}
+						}
 					}
 				}
 			}
@@ -137,75 +317,423 @@ func (b *Board) TrimAllowedFromPerms() bool {
 	return changed
 }
 
-// AutoSolve runs all implemented solving heuristics until the puzzle is solved
-// or we run out of improvements. Missing heuristics include the opposite of
-// naked sets (i.e., cells X and Y are the only possible locations for numbers
-// N and M, so X and Y can't have any other numbers) and pairwise permutation
-// consistency between rows or columns.
-func (b *Board) AutoSolve() error {
-	changed := true
-	for changed && b.Solved() != nil {
-		fmt.Printf("New round\n")
-		changed = false
-		if b.MarkMandatory() {
-			fmt.Printf("MM true\n")
-			changed = true
+// valuePositions returns, in ascending order, the positions along row or
+// column index (depending on t) where v can still go. The line's permutation
+// list is used if it has one, and otherwise its cells' Allowed lists.
+func (b *Board) valuePositions(t, index, v int) []int {
+	seen := make([]bool, b.Size)
+	perms := b.RowPerms[index]
+	if t == OBS_COL {
+		perms = b.ColPerms[index]
+	}
+	if perms != nil {
+		for _, pi := range *perms {
+			for i, x := range b.Perms[pi] {
+				if x == v {
+					seen[i] = true
+					break
+				}
+			}
 		}
-		if b.TrimAllowedFromPerms() {
-			fmt.Printf("TAFP true\n")
+	} else {
+		for i := 0; i < b.Size; i++ {
+			ri, ci := index, i
+			if t == OBS_COL {
+				ri, ci = i, index
+			}
+			val := b.Get(ri, ci)
+			seen[i] = val == v || (val == EMPTY && b.IsAllowed(ri, ci, v))
+		}
+	}
+	out := make([]int, 0, 2)
+	for i, ok := range seen {
+		if ok {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// TrimByVisibility removes candidates that cannot be part of any arrangement
+// achieving an observer's count. It uses only Allowed, Grid and Observers, so
+// it works even when Perms has not been generated. For a value v at distance d
+// from an observer:
+//
+//   - up to d+1 towers can be visible up to and including v, but only d if
+//     v <= d, since v can't then be taller than all d towers in front of it;
+//     behind v, at most min(Size-v, Size-1-d) towers can be visible, since
+//     they must be taller than v. If that falls short of the count, v can't
+//     go there;
+//   - the tallest tower is always visible, and so is the first tower, so a
+//     short first tower or a tallest tower further back means at least two
+//     towers are visible;
+//   - the filled cells nearest the observer, with v in place, must not
+//     already show too many towers, or hide the rest of the line with the
+//     wrong count.
+//
+// Returns true iff at least one candidate was removed.
+func (b *Board) TrimByVisibility() bool {
+	changed := false
+	for _, o := range b.Observers {
+		if b.trimByObserver(o) {
 			changed = true
 		}
-		if b.TrimPermsFromAllowed() {
-			fmt.Printf("TPFA true\n")
+	}
+	return changed
+}
+
+// trimByObserver applies the TrimByVisibility bounds for the single observer
+// o. Returns true iff at least one candidate was removed.
+func (b *Board) trimByObserver(o *Observer) bool {
+	changed := false
+	ri, ci, dr, dc := b.ObserverPath(o)
+	b.because("TrimByVisibility", "%s", o)
+	for d := 0; d < b.Size; d++ {
+		r, c := ri+dr*d, ci+dc*d
+		if b.Get(r, c) != EMPTY {
+			continue
+		}
+		for v := 1; v <= b.Size; v++ {
+			if !b.IsAllowed(r, c, v) {
+				continue
+			}
+			if !b.visibilityFeasible(o, d, v) {
+				b.disallow(r, c, v)
+				b.noteElim(r, c, v)
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// TrimByDualObserver removes candidates that can't appear in any arrangement
+// of a line satisfying both of its observers at once. For every clued line,
+// it walks through the ways of filling the line's empty cells from their
+// Allowed lists, pruning with PermFitsObsPartial, and keeps only the values
+// that turn up in some complete arrangement fitting the clues. A line with a
+// clue at only one end is walked the same way against that clue alone, which
+// finds every deduction the single-sided bounds of TrimByVisibility would and
+// more. Like TrimByVisibility, it doesn't need Perms.
+// Cells left with a single candidate are marked with MarkMandatory as soon
+// as each line is done, rather than in the next round. Returns true iff at
+// least one candidate was removed.
+func (b *Board) TrimByDualObserver() bool {
+	changed := false
+	for line := 0; line < b.Size*2 && !b.timeUp(); line++ {
+		fwd, bwd := b.lineObservers(line)
+		if fwd == nil && bwd == nil {
+			continue
+		}
+		if b.trimLineByDualObserver(line, fwd, bwd) {
+			// Mark any cells the trim has settled straight away, so the
+			// lines after this one start from the placements.
+			b.MarkMandatory()
 			changed = true
 		}
-		if !changed {
-			for n := 2; n < b.Size-1 && !changed; n++ {
-				if b.TrimNakedSets(n) {
-					fmt.Printf("TNS(%d) true\n", n)
-					changed = true
+	}
+	return changed
+}
+
+// trimLineByDualObserver does the work of TrimByDualObserver for one line,
+// numbered as in ObsSorted: rows first, then columns. Either observer may be
+// nil.
+func (b *Board) trimLineByDualObserver(line int, fwd, bwd *Observer) bool {
+	cell := func(i int) (int, int) {
+		if line < b.Size {
+			return line, i
+		}
+		return i, line - b.Size
+	}
+	vals := make([]int, b.Size)
+	used := make([]bool, b.Size+1)
+	seen := make([]Set[int], b.Size)
+	for i := range vals {
+		vals[i] = b.Get(cell(i))
+		used[vals[i]] = true
+		seen[i] = NewSet[int]()
+	}
+	var walk func(i int)
+	walk = func(i int) {
+		if i == b.Size {
+			if PermFitsObs(vals, fwd, bwd) {
+				for j, v := range vals {
+					seen[j].Add(v)
 				}
 			}
+			return
 		}
-		if !changed {
-			for n := 2; n < b.Size-1 && !changed; n++ {
-				if b.TrimFoundGroups(n) {
-					fmt.Printf("TFG(%d) true\n", n)
-					changed = true
-				}
+		if vals[i] != EMPTY {
+			walk(i + 1)
+			return
+		}
+		ri, ci := cell(i)
+		for _, v := range b.Candidates(ri, ci) {
+			if used[v] {
+				continue
 			}
+			vals[i] = v
+			used[v] = true
+			if PermFitsObsPartial(vals, fwd, bwd) {
+				walk(i + 1)
+			}
+			used[v] = false
+			vals[i] = EMPTY
 		}
 	}
-	return b.Solved()
+	walk(0)
+	if line < b.Size {
+		b.because("TrimByDualObserver", "row %d clues", line)
+	} else {
+		b.because("TrimByDualObserver", "col %d clues", line-b.Size)
+	}
+	changed := false
+	for i := range vals {
+		ri, ci := cell(i)
+		if b.Get(ri, ci) != EMPTY {
+			continue
+		}
+		for _, v := range b.Candidates(ri, ci) {
+			if !seen[i].Has(v) {
+				b.disallow(ri, ci, v)
+				b.noteElim(ri, ci, v)
+				changed = true
+			}
+		}
+	}
+	return changed
 }
 
-// NumSetsEqual return strue iff the two maps contain exactly the same keys.
-func NumSetsEqual(a, b map[int]interface{}) bool {
-	if len(a) != len(b) {
+// visibilityFeasible applies the bounds described in TrimByVisibility to
+// value v at distance d from observer o.
+func (b *Board) visibilityFeasible(o *Observer, d, v int) bool {
+	maxVis := d + min(b.Size-v, b.Size-1-d)
+	if v > d {
+		maxVis++
+	}
+	if maxVis < o.Count {
 		return false
 	}
-	for k, _ := range a {
-		if _, ok := b[k]; !ok {
+	minVis := 1
+	if (d == 0 && v < b.Size) || (d > 0 && v == b.Size) {
+		minVis = 2
+	}
+	if minVis > o.Count {
+		return false
+	}
+	ri, ci, dr, dc := b.ObserverPath(o)
+	vis := 0
+	highest := 0
+	for i := 0; i < b.Size; i++ {
+		val := b.Get(ri, ci)
+		if i == d {
+			val = v
+		}
+		if val == EMPTY {
+			break
+		}
+		if val > highest {
+			vis++
+			highest = val
+		}
+		if vis > o.Count {
 			return false
 		}
+		if val == b.Size {
+			return vis == o.Count
+		}
+		ri += dr
+		ci += dc
 	}
 	return true
 }
 
+// AutoSolve runs the board's Heuristics (or DefaultHeuristics if none are set)
+// in order, round after round, until the puzzle is solved or we run out of
+// improvements. Missing heuristics include the opposite of naked sets (i.e.,
+// cells X and Y are the only possible locations for numbers N and M, so X and
+// Y can't have any other numbers) and pairwise permutation consistency between
+// rows or columns.
+//
+// If the heuristics stall, a bounded search counts the solutions so the error
+// says why: it wraps ErrUnsatisfiable if there are none, ErrAmbiguous if there
+// are several and ErrNeedsGuessing if the puzzle is sound but needs guessing.
+// If the search runs out of guesses before it can tell, the error is
+// ErrNeedsGuessing itself.
+// The board is left as far as logic got it in every case. If a round in which
+// some heuristic reported progress leaves the board unchanged, the loop would
+// never end, so AutoSolve stops with an error wrapping ErrInternal that names
+// the heuristic.
+func (b *Board) AutoSolve() error {
+	return b.AutoSolveContext(context.Background())
+}
+
+// AutoSolveContext is AutoSolve with cancellation. ctx is checked before each
+// heuristic runs, and ctx.Err() is returned as soon as it is canceled or its
+// deadline passes. The board keeps the progress made so far; see SolveContext
+// for a result that reports it.
+func (b *Board) AutoSolveContext(ctx context.Context) error {
+	err := b.autoSolve(ctx, nil)
+	if err == nil || errors.Is(err, ErrUnsatisfiable) || errors.Is(err, ErrAmbiguous) || errors.Is(err, ErrInternal) || ctx.Err() != nil {
+		return err
+	}
+	return b.stallError(ctx)
+}
+
+// SolveLowMemory solves the board without ever generating Perms, for boards
+// too large for the permutation lists to fit in memory. It sets NoPerms,
+// discards any Perms already generated, runs AutoSolve with
+// LowMemoryHeuristics and falls back to BruteSolve if they get stuck. Boards
+// larger than MaxPermSize are solved this way by AutoSolve anyway.
+func (b *Board) SolveLowMemory() error {
+	b.NoPerms = true
+	b.Perms = nil
+	for i := 0; i < b.Size; i++ {
+		b.RowPerms[i] = nil
+		b.ColPerms[i] = nil
+	}
+	err := b.autoSolve(context.Background(), nil)
+	if err != nil && !errors.Is(err, ErrUnsatisfiable) {
+		err = b.bruteSolve(context.Background(), nil, nil, false)
+	}
+	return err
+}
+
+// autoSolve is the main solving loop behind AutoSolve. If stats is non-nil,
+// the rounds and the activity of each heuristic are recorded in it.
+func (b *Board) autoSolve(ctx context.Context, stats *SolveStats) error {
+	b.InitPerms()
+	return b.autoSolveWith(ctx, stats, b.activeHeuristics())
+}
+
+// activeHeuristics returns the heuristics AutoSolve runs on the board: its own
+// Heuristics if set, and otherwise DefaultHeuristics, or LowMemoryHeuristics
+// if it has no Perms, with the set searches limited by MaxSetSize.
+func (b *Board) activeHeuristics() []Heuristic {
+	if b.Heuristics != nil {
+		return b.Heuristics
+	}
+	if b.Perms == nil {
+		return lowMemoryHeuristics(b.MaxSetSize)
+	}
+	return defaultHeuristics(b.MaxSetSize)
+}
+
+// autoSolveWith is autoSolve running the given heuristics instead of the
+// board's own.
+func (b *Board) autoSolveWith(ctx context.Context, stats *SolveStats, heuristics []Heuristic) error {
+	if b.NumEmpty == 0 {
+		// Nothing to deduce: the board is either solved or wrong.
+		return b.Solved()
+	}
+	if len(b.Observers) == 0 && b.NumEmpty == b.Size*b.Size && b.Size > 1 {
+		// Without clues or givens, every Latin square is a solution.
+		return fmt.Errorf("board has no clues or filled cells: %w", ErrAmbiguous)
+	}
+	b.InitPerms()
+	if err := b.emptyPermsError(); err != nil {
+		return err
+	}
+	changed := true
+	round := 0
+	for changed && b.Solved() != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		before := b.fingerprint()
+		lastFired := ""
+		round++
+		if stats != nil {
+			stats.Rounds = round
+		}
+		b.logf("New round\n")
+		changed = false
+		for _, h := range heuristics {
+			if h.Fallback && changed {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			removed := 0
+			var err error
+			if b.HeuristicTimeout > 0 {
+				b.deadline = time.Now().Add(b.HeuristicTimeout)
+			}
+			fired := stats.track(b, h.Name, func() bool {
+				removed, err = h.run(b)
+				return removed > 0
+			})
+			b.deadline = time.Time{}
+			if err != nil {
+				return err
+			}
+			if fired {
+				if h.Count != nil {
+					b.logf("%s removed %d\n", h.Name, removed)
+				} else {
+					b.logf("%s true\n", h.Name)
+				}
+				changed = true
+				lastFired = h.Name
+			}
+			if ri, ci, ok := b.FindContradiction(); ok {
+				return noCandidatesError(ri, ci)
+			}
+		}
+		if changed && b.fingerprint() == before {
+			// A heuristic claimed progress without making any, and would
+			// go on doing so every round.
+			return fmt.Errorf("round %d made no progress, though %s reported some: %w", round, lastFired, ErrInternal)
+		}
+		if b.Progress != nil {
+			b.Progress(round, b.NumEmpty)
+		}
+	}
+	return b.Solved()
+}
+
+// A boardFingerprint summarizes how far solving has got. Every step the
+// heuristics take fills a cell or removes candidates or permutations, so the
+// fingerprint changes whenever any progress is made.
+type boardFingerprint struct {
+	numEmpty   int
+	candidates int
+	perms      int
+}
+
+// fingerprint returns the board's current boardFingerprint.
+func (b *Board) fingerprint() boardFingerprint {
+	fp := boardFingerprint{numEmpty: b.NumEmpty, candidates: b.NumCandidates()}
+	for line := 0; line < b.Size*2; line++ {
+		if list := b.linePermsFor(line); list != nil {
+			fp.perms += len(*list)
+		}
+	}
+	return fp
+}
+
+// logf prints a log message if the board is in verbose mode.
+func (b *Board) logf(format string, args ...interface{}) {
+	if b.Verbose {
+		fmt.Printf(format, args...)
+	}
+}
+
 // CheckRowNakedSet returns true iff row rowIndex contains a naked set at the
-// indices specified in indices.
+// indices specified in indices. Every cell in the set must be empty.
 func (b *Board) CheckRowNakedSet(indices []int, rowIndex int) bool {
 	if len(indices) == 0 {
 		return false
 	}
-	if len(indices) != len(b.Allowed[rowIndex][indices[0]]) {
+	if len(indices) != b.Allowed[rowIndex][indices[0]].Len() {
 		return false
 	}
-	for _, idx := range indices[1:] {
+	for _, idx := range indices {
 		if b.Grid[rowIndex][idx] != EMPTY {
 			return false
 		}
-		if !NumSetsEqual(b.Allowed[rowIndex][idx], b.Allowed[rowIndex][indices[0]]) {
+		if !b.Allowed[rowIndex][idx].Equal(b.Allowed[rowIndex][indices[0]]) {
 			return false
 		}
 	}
@@ -213,19 +741,19 @@ func (b *Board) CheckRowNakedSet(indices []int, rowIndex int) bool {
 }
 
 // CheckColumnNakedSet returns true iff col colIndex contains a naked set at
-// the indices specified in indices.
+// the indices specified in indices. Every cell in the set must be empty.
 func (b *Board) CheckColumnNakedSet(indices []int, colIndex int) bool {
 	if len(indices) == 0 {
 		return false
 	}
-	if len(indices) != len(b.Allowed[indices[0]][colIndex]) {
+	if len(indices) != b.Allowed[indices[0]][colIndex].Len() {
 		return false
 	}
-	for _, idx := range indices[1:] {
+	for _, idx := range indices {
 		if b.Grid[idx][colIndex] != EMPTY {
 			return false
 		}
-		if !NumSetsEqual(b.Allowed[idx][colIndex], b.Allowed[indices[0]][colIndex]) {
+		if !b.Allowed[idx][colIndex].Equal(b.Allowed[indices[0]][colIndex]) {
 			return false
 		}
 	}
@@ -243,23 +771,40 @@ func SliceContains(haystack []int, needle int) bool {
 }
 
 // DisallowAll removes all entries in toRemove from the Allowed list for cell
-// ri, ci. Returns true iff at least one entry was removed.
-func (b *Board) DisallowAll(ri, ci int, toRemove map[int]interface{}) bool {
-	changed := false
-	for k, _ := range toRemove {
-		if _, ok := b.Allowed[ri][ci][k]; ok {
-			delete(b.Allowed[ri][ci], k)
-			changed = true
+// ri, ci, in ascending order. Returns true iff at least one entry was removed.
+func (b *Board) DisallowAll(ri, ci int, toRemove Set[int]) bool {
+	return b.disallowAll(ri, ci, toRemove) > 0
+}
+
+// disallowAll does the work of DisallowAll, returning the number of entries
+// removed.
+func (b *Board) disallowAll(ri, ci int, toRemove Set[int]) int {
+	removed := 0
+	for k := 1; k <= b.Size; k++ {
+		if !toRemove.Has(k) {
+			continue
+		}
+		if b.Allowed[ri][ci].Has(k) {
+			b.disallow(ri, ci, k)
+			b.noteElim(ri, ci, k)
+			removed++
 		}
 	}
-	return changed
+	return removed
 }
 
 // DisallowOthers removes all numbers *not* in toKeep from the Allowed list
-// for cell ri, ci. Returns true iff at least one entry was removed.
+// for cell ri, ci, in ascending order. Returns true iff at least one entry was
+// removed.
 func (b *Board) DisallowOthers(ri, ci int, toKeep []int) bool {
-	changed := false
-	for k, _ := range b.Allowed[ri][ci] {
+	return b.disallowOthers(ri, ci, toKeep) > 0
+}
+
+// disallowOthers does the work of DisallowOthers, returning the number of
+// entries removed.
+func (b *Board) disallowOthers(ri, ci int, toKeep []int) int {
+	removed := 0
+	for k := 1; k <= b.Size; k++ {
 		canKeep := false
 		for _, v := range toKeep {
 			if v == k {
@@ -270,12 +815,13 @@ func (b *Board) DisallowOthers(ri, ci int, toKeep []int) bool {
 		if canKeep {
 			continue
 		}
-		if _, ok := b.Allowed[ri][ci][k]; ok {
-			delete(b.Allowed[ri][ci], k)
-			changed = true
+		if b.Allowed[ri][ci].Has(k) {
+			b.disallow(ri, ci, k)
+			b.noteElim(ri, ci, k)
+			removed++
 		}
 	}
-	return changed
+	return removed
 }
 
 // TrimNakedSets looks at each row and column for naked sets of size n and
@@ -285,36 +831,41 @@ func (b *Board) DisallowOthers(ri, ci int, toKeep []int) bool {
 // A and B are [1, 2]. It allows us to eliminate 1 and 2 from the allowed lists
 // of other cells in the same line.
 func (b *Board) TrimNakedSets(n int) bool {
+	return b.TrimNakedSetsCount(n) > 0
+}
+
+// TrimNakedSetsCount does the work of TrimNakedSets, returning the number of
+// candidates removed.
+func (b *Board) TrimNakedSetsCount(n int) int {
+	removed := 0
 	indices := Permute(0, b.Size-1, n)
-	for ri := 0; ri < b.Size; ri++ {
+	for ri := 0; ri < b.Size && !b.timeUp(); ri++ {
 		for _, idxs := range indices {
 			if b.CheckRowNakedSet(idxs, ri) {
+				b.because("TrimNakedSets", "row %d cols %v", ri, idxs)
 				for ci := 0; ci < b.Size; ci++ {
 					if SliceContains(idxs, ci) {
 						continue
 					}
-					if b.DisallowAll(ri, ci, b.Allowed[ri][idxs[0]]) {
-						return true
-					}
+					removed += b.disallowAll(ri, ci, b.Allowed[ri][idxs[0]])
 				}
 			}
 		}
 	}
-	for ci := 0; ci < b.Size; ci++ {
+	for ci := 0; ci < b.Size && !b.timeUp(); ci++ {
 		for _, idxs := range indices {
 			if b.CheckColumnNakedSet(idxs, ci) {
+				b.because("TrimNakedSets", "col %d rows %v", ci, idxs)
 				for ri := 0; ri < b.Size; ri++ {
 					if SliceContains(idxs, ri) {
 						continue
 					}
-					if b.DisallowAll(ri, ci, b.Allowed[idxs[0]][ci]) {
-						return true
-					}
+					removed += b.disallowAll(ri, ci, b.Allowed[idxs[0]][ci])
 				}
 			}
 		}
 	}
-	return false
+	return removed
 }
 
 // TrimFoundGroups looks at each row and column for found groups of size n and
@@ -325,133 +876,123 @@ func (b *Board) TrimNakedSets(n int) bool {
 // go in those two cells, all other numbers can be removed from their allowed
 // lists. TODO: update with the correct term for "found groups!"
 func (b *Board) TrimFoundGroups(n int) bool {
-	changed := false
-	numbers := Permute(1, b.Size, n)
-	for _, nums := range numbers {
+	return b.TrimFoundGroupsCount(n) > 0
+}
+
+// TrimFoundGroupsCount does the work of TrimFoundGroups, returning the number
+// of candidates removed. Each line's candidate positions are worked out once
+// with candidatePositions and reused for every combination of numbers, and only
+// the lines a group touches are recomputed after it removes candidates, so
+// groups are found in the same order as checking every line afresh would.
+func (b *Board) TrimFoundGroupsCount(n int) int {
+	removed := 0
+	pos := make([][]uint64, b.Size*2)
+	for line := range pos {
+		pos[line] = b.candidatePositions(line, nil)
+	}
+	PermuteFunc(1, b.Size, n, func(nums []int) bool {
 		for ri := 0; ri < b.Size; ri++ {
-			if !b.CheckRowFoundGroup(nums, ri) {
+			if !isFoundGroup(pos[ri], nums) {
 				continue
 			}
+			b.because("TrimFoundGroups", "row %d numbers %v", ri, nums)
+			cells := pos[ri][nums[0]]
+			got := 0
 			for ci := 0; ci < b.Size; ci++ {
-				if b.IsAllowed(ri, ci, nums[0]) {
-					if b.DisallowOthers(ri, ci, nums) {
-						changed = true
-					}
+				if cells&(1<<ci) != 0 {
+					got += b.disallowOthers(ri, ci, nums)
 				}
 			}
+			if got > 0 {
+				b.refreshPositions(pos, ri, cells)
+			}
+			removed += got
 		}
 		for ci := 0; ci < b.Size; ci++ {
-			if !b.CheckColFoundGroup(nums, ci) {
+			if !isFoundGroup(pos[b.Size+ci], nums) {
 				continue
 			}
+			b.because("TrimFoundGroups", "col %d numbers %v", ci, nums)
+			cells := pos[b.Size+ci][nums[0]]
+			got := 0
 			for ri := 0; ri < b.Size; ri++ {
-				if b.IsAllowed(ri, ci, nums[0]) {
-					if b.DisallowOthers(ri, ci, nums) {
-						changed = true
-					}
+				if cells&(1<<ri) != 0 {
+					got += b.disallowOthers(ri, ci, nums)
 				}
 			}
+			if got > 0 {
+				b.refreshPositions(pos, b.Size+ci, cells)
+			}
+			removed += got
 		}
-	}
-	return changed
+		return !b.timeUp()
+	})
+	return removed
 }
 
-// CheckRowFoundGroup returns true iff row rowIndex contains a found group for
-// the numbers specified in numbers.
-func (b *Board) CheckRowFoundGroup(numbers []int, rowIndex int) bool {
-	numberCells := make([]map[int]interface{}, len(numbers))
-	for i, _ := range numbers {
-		numberCells[i] = make(map[int]interface{})
+// candidatePositions returns, for line number line, a bitset for each value v at
+// index v, with bit i set iff v is still allowed at position i of the line.
+// Lines 0 to Size-1 are rows and Size to 2*Size-1 are columns. out is reused
+// if it is long enough.
+func (b *Board) candidatePositions(line int, out []uint64) []uint64 {
+	if len(out) < b.Size+1 {
+		out = make([]uint64, b.Size+1)
 	}
-	for coli := 0; coli < b.Size; coli++ {
-		for nidx, num := range numbers {
-			if b.IsAllowed(rowIndex, coli, num) {
-				numberCells[nidx][coli] = nil
-			}
-		}
+	for v := range out {
+		out[v] = 0
 	}
-	if len(numberCells[0]) != len(numbers) {
-		return false
-	}
-	for i := 1; i < len(numbers); i++ {
-		if !NumSetsEqual(numberCells[i], numberCells[0]) {
-			return false
+	for i := 0; i < b.Size; i++ {
+		ri, ci := line, i
+		if line >= b.Size {
+			ri, ci = i, line-b.Size
+		}
+		for _, v := range b.Allowed[ri][ci].Keys() {
+			out[v] |= 1 << i
 		}
 	}
-	return true
+	return out
 }
 
-// CheckColFoundGroup returns true iff col colIndex contains a found group for
-// the numbers specified in numbers.
-func (b *Board) CheckColFoundGroup(numbers []int, colIndex int) bool {
-	numberCells := make([]map[int]interface{}, len(numbers))
-	for i, _ := range numbers {
-		numberCells[i] = make(map[int]interface{})
+// refreshPositions recomputes pos for line number line and for each line
+// crossing it at a position set in cells, after candidates were removed from
+// those cells.
+func (b *Board) refreshPositions(pos [][]uint64, line int, cells uint64) {
+	pos[line] = b.candidatePositions(line, pos[line])
+	cross := b.Size
+	if line >= b.Size {
+		cross = 0
 	}
-	for rowi := 0; rowi < b.Size; rowi++ {
-		for nidx, num := range numbers {
-			if b.IsAllowed(rowi, colIndex, num) {
-				numberCells[nidx][rowi] = nil
-			}
+	for i := 0; i < b.Size; i++ {
+		if cells&(1<<i) != 0 {
+			pos[cross+i] = b.candidatePositions(cross+i, pos[cross+i])
 		}
 	}
-	if len(numberCells[0]) != len(numbers) {
+}
+
+// isFoundGroup returns true iff the numbers in nums have exactly len(nums)
+// possible homes in a line, all the same, given the line's positions from
+// candidatePositions.
+func isFoundGroup(pos []uint64, nums []int) bool {
+	cells := pos[nums[0]]
+	if bits.OnesCount64(cells) != len(nums) {
 		return false
 	}
-	for i := 1; i < len(numbers); i++ {
-		if !NumSetsEqual(numberCells[i], numberCells[0]) {
+	for _, v := range nums[1:] {
+		if pos[v] != cells {
 			return false
 		}
 	}
 	return true
 }
 
-func testRowFoundGroup() {
-	str := "       \n"
-	str += "       \n"
-	str += "       \n"
-	str += "       \n"
-	str += "       \n"
-	str += "       \n"
-	str += "       \n"
-	b, err := BoardFromString(str)
-	if err != nil {
-		log.Fatalf("%v", err)
-	}
-	fmt.Printf("sz %d\n%s\n", b.Size, b)
-	b.DisallowOthers(0, 0, []int{2, 3, 4})
-	b.DisallowOthers(0, 1, []int{2, 3, 5})
-	b.DisallowOthers(0, 2, []int{1, 4, 5})
-	b.DisallowOthers(0, 3, []int{1, 4, 5})
-	b.DisallowOthers(0, 4, []int{1, 4, 5})
-	b.PrintAllowed()
-	res := b.TrimFoundGroups(2)
-	fmt.Printf("%v\n", res)
-	fmt.Printf("sz %d\n%s\n", b.Size, b)
-	b.PrintAllowed()
-}
-
-func testColFoundGroup() {
-	str := "       \n"
-	str += "       \n"
-	str += "       \n"
-	str += "       \n"
-	str += "       \n"
-	str += "       \n"
-	str += "       \n"
-	b, err := BoardFromString(str)
-	if err != nil {
-		log.Fatalf("%v", err)
-	}
-	fmt.Printf("sz %d\n%s\n", b.Size, b)
-	b.DisallowOthers(0, 0, []int{2, 3, 4})
-	b.DisallowOthers(1, 0, []int{2, 3, 5})
-	b.DisallowOthers(2, 0, []int{1, 4, 5})
-	b.DisallowOthers(3, 0, []int{1, 4, 5})
-	b.DisallowOthers(4, 0, []int{1, 4, 5})
-	b.PrintAllowed()
-	res := b.TrimFoundGroups(2)
-	fmt.Printf("%v\n", res)
-	fmt.Printf("sz %d\n%s\n", b.Size, b)
-	b.PrintAllowed()
+// CheckRowFoundGroup returns true iff row rowIndex contains a found group for
+// the numbers specified in numbers.
+func (b *Board) CheckRowFoundGroup(numbers []int, rowIndex int) bool {
+	return isFoundGroup(b.candidatePositions(rowIndex, nil), numbers)
+}
+
+// CheckColFoundGroup returns true iff col colIndex contains a found group for
+// the numbers specified in numbers.
+func (b *Board) CheckColFoundGroup(numbers []int, colIndex int) bool {
+	return isFoundGroup(b.candidatePositions(b.Size+colIndex, nil), numbers)
 }
@@ -0,0 +1,594 @@
+package towers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// bundledPuzzles are the sample puzzles in the repository root.
+var bundledPuzzles = []string{
+	"problem1.txt", "problem2.txt", "problem3.txt",
+	"problem4.txt", "problem5.txt", "problem6.txt",
+}
+
+// loadPuzzle parses one of the bundled puzzles, failing the test or benchmark
+// if it can't.
+func loadPuzzle(tb testing.TB, name string) *Board {
+	tb.Helper()
+	b, err := BoardFromFile(name)
+	if err != nil {
+		tb.Fatalf("loading %s: %v", name, err)
+	}
+	return b
+}
+
+// BenchmarkSolve measures a full Solve of each bundled puzzle and of a
+// generated size-7 puzzle. Run it with and without -tags bitset to compare
+// the two Set representations.
+func BenchmarkSolve(b *testing.B) {
+	boards := map[string]*Board{}
+	for _, name := range bundledPuzzles {
+		boards[name] = loadPuzzle(b, name)
+	}
+	gen, _ := Generate(7, 1)
+	boards["generated-7"] = gen
+	for _, name := range append(bundledPuzzles, "generated-7") {
+		puzzle := boards[name]
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c := puzzle.Clone()
+				if res := c.Solve(); res.Err != nil {
+					b.Fatal(fmt.Errorf("%s: %w", name, res.Err))
+				}
+			}
+		})
+	}
+}
+
+// oldTrimFoundGroupsCount is TrimFoundGroupsCount as it was before it kept
+// candidate positions as bitsets: every line is checked afresh, with Sets,
+// for every combination of numbers. It is kept as the reference the faster
+// version must agree with.
+func oldTrimFoundGroupsCount(b *Board, n int) int {
+	lineCells := func(numbers []int, ri, ci int) bool {
+		numberCells := make([]Set[int], len(numbers))
+		for i := range numbers {
+			numberCells[i] = NewSet[int]()
+		}
+		for i := 0; i < b.Size; i++ {
+			r, c := ri, i
+			if ri < 0 {
+				r, c = i, ci
+			}
+			for nidx, num := range numbers {
+				if b.IsAllowed(r, c, num) {
+					numberCells[nidx].Add(i)
+				}
+			}
+		}
+		if numberCells[0].Len() != len(numbers) {
+			return false
+		}
+		for i := 1; i < len(numbers); i++ {
+			if !numberCells[i].Equal(numberCells[0]) {
+				return false
+			}
+		}
+		return true
+	}
+	removed := 0
+	PermuteFunc(1, b.Size, n, func(nums []int) bool {
+		for ri := 0; ri < b.Size; ri++ {
+			if !lineCells(nums, ri, -1) {
+				continue
+			}
+			b.because("TrimFoundGroups", "row %d numbers %v", ri, nums)
+			for ci := 0; ci < b.Size; ci++ {
+				if b.IsAllowed(ri, ci, nums[0]) {
+					removed += b.disallowOthers(ri, ci, nums)
+				}
+			}
+		}
+		for ci := 0; ci < b.Size; ci++ {
+			if !lineCells(nums, -1, ci) {
+				continue
+			}
+			b.because("TrimFoundGroups", "col %d numbers %v", ci, nums)
+			for ri := 0; ri < b.Size; ri++ {
+				if b.IsAllowed(ri, ci, nums[0]) {
+					removed += b.disallowOthers(ri, ci, nums)
+				}
+			}
+		}
+		return true
+	})
+	return removed
+}
+
+// foundGroupBoards returns the bundled puzzles as parsed, and as the
+// heuristics before TrimFoundGroups leave them, along with hardBoard8.
+func foundGroupBoards(tb testing.TB) []*Board {
+	var out []*Board
+	for _, name := range bundledPuzzles {
+		b := loadPuzzle(tb, name)
+		out = append(out, b.Clone())
+		b.MarkMandatory()
+		b.TrimPermsFromAllowed()
+		b.TrimAllowedFromPerms()
+		out = append(out, b)
+	}
+	return append(out, hardBoard8(tb))
+}
+
+func TestTrimFoundGroupsMatchesOld(t *testing.T) {
+	fired := 0
+	for i, puzzle := range foundGroupBoards(t) {
+		for n := 1; n < puzzle.Size; n++ {
+			want, got := puzzle.Clone(), puzzle.Clone()
+			want.EnableReasonTracking()
+			got.EnableReasonTracking()
+			wantCount := oldTrimFoundGroupsCount(want, n)
+			gotCount := got.TrimFoundGroupsCount(n)
+			if gotCount != wantCount {
+				t.Errorf("board %d, n = %d: removed %d candidates, old code removed %d", i, n, gotCount, wantCount)
+			}
+			if !got.EqualsStrict(want) {
+				t.Errorf("board %d, n = %d: candidates differ:\n%s\nold code:\n%s", i, n, got.CandidatesString(), want.CandidatesString())
+			}
+			if !reflect.DeepEqual(got.Reasons, want.Reasons) {
+				t.Errorf("board %d, n = %d: reasons differ:\n%v\nold code:\n%v", i, n, got.Reasons, want.Reasons)
+			}
+			if gotCount > 0 {
+				fired++
+			}
+		}
+	}
+	if fired == 0 {
+		t.Error("TrimFoundGroups never removed anything, so the comparison proves nothing")
+	}
+	t.Logf("TrimFoundGroups fired %d times", fired)
+}
+
+// BenchmarkTrimFoundGroups and BenchmarkTrimFoundGroupsOld run every group
+// size over the boards from foundGroupBoards with the bitset version and the
+// old Set-based one.
+func BenchmarkTrimFoundGroups(b *testing.B) {
+	benchmarkFoundGroups(b, func(c *Board, n int) int { return c.TrimFoundGroupsCount(n) })
+}
+
+func BenchmarkTrimFoundGroupsOld(b *testing.B) {
+	benchmarkFoundGroups(b, oldTrimFoundGroupsCount)
+}
+
+func benchmarkFoundGroups(b *testing.B, trim func(*Board, int) int) {
+	boards := foundGroupBoards(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, puzzle := range boards {
+			c := puzzle.Clone()
+			for n := 1; n < c.Size; n++ {
+				trim(c, n)
+			}
+		}
+	}
+}
+
+func TestNakedSetFilledAnchor(t *testing.T) {
+	// Cell (0, 0) is filled but still has the stale candidates {1, 2}, the
+	// same as the empty cell next to it, which must not make a naked set.
+	b := NewBoard(4)
+	b.setGrid(0, 0, 1)
+	for _, k := range []CellKey{{0, 0}, {0, 1}, {1, 0}} {
+		b.Allowed[k.Row][k.Col] = NewSet(1, 2)
+	}
+	if b.CheckRowNakedSet([]int{0, 1}, 0) {
+		t.Error("CheckRowNakedSet accepted a set anchored on a filled cell")
+	}
+	if b.CheckColumnNakedSet([]int{0, 1}, 0) {
+		t.Error("CheckColumnNakedSet accepted a set anchored on a filled cell")
+	}
+	// With the anchor emptied, the same cells do form a naked set.
+	b.setGrid(0, 0, EMPTY)
+	if !b.CheckRowNakedSet([]int{0, 1}, 0) || !b.CheckColumnNakedSet([]int{0, 1}, 0) {
+		t.Error("naked set over two empty cells was not found")
+	}
+}
+
+// cancelAfter is a context that reports itself canceled from the n+1th call
+// to Err on, so a test can stop a solve at a chosen point.
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestCancelMidSolveLeavesBoardConsistent(t *testing.T) {
+	puzzles := map[string]string{"hardPuzzle8": hardPuzzle8}
+	for _, name := range bundledPuzzles {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		puzzles[name] = string(data)
+	}
+	partial := 0
+	for name, puzzle := range puzzles {
+		for n := 0; n < 20; n++ {
+			b, err := BoardFromString(puzzle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := b.NumEmpty
+			res := b.SolveContext(&cancelAfter{Context: context.Background(), n: n})
+			if errors.Is(res.Err, context.Canceled) && res.NumEmpty > 0 && res.NumEmpty < start {
+				partial++
+			}
+			if res.Err != nil && !errors.Is(res.Err, context.Canceled) {
+				t.Errorf("%s, canceled after %d checks: %v", name, n, res.Err)
+			}
+			if res.NumEmpty != b.NumEmpty {
+				t.Errorf("%s, canceled after %d checks: result has NumEmpty %d, board %d", name, n, res.NumEmpty, b.NumEmpty)
+			}
+			if err := b.CheckConsistency(); err != nil {
+				t.Errorf("%s, canceled after %d checks: %v", name, n, err)
+			}
+
+			b, _ = BoardFromString(puzzle)
+			err = b.AutoSolveContext(&cancelAfter{Context: context.Background(), n: n})
+			if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, ErrNeedsGuessing) {
+				t.Errorf("%s, AutoSolveContext canceled after %d checks: %v", name, n, err)
+			}
+			if err := b.CheckConsistency(); err != nil {
+				t.Errorf("%s, AutoSolveContext canceled after %d checks: %v", name, n, err)
+			}
+		}
+	}
+	if partial == 0 {
+		t.Error("no solve was canceled part way through")
+	}
+}
+
+func TestSingleClueLineDeductions(t *testing.T) {
+	// A lone clue on an empty board leaves each cell of its line with
+	// exactly the values the fitting permutations put there.
+	for n := 2; n <= 6; n++ {
+		for count := 1; count <= n; count++ {
+			for dir := OBS_FWD; dir <= OBS_BWD; dir++ {
+				o := &Observer{Type: OBS_ROW, Index: 0, Direction: dir, Count: count}
+				want := make([]Set[int], n)
+				for i := range want {
+					want[i] = NewSet[int]()
+				}
+				fwd, bwd := o, (*Observer)(nil)
+				if dir == OBS_BWD {
+					fwd, bwd = nil, o
+				}
+				for _, p := range PermuteN(n) {
+					if PermFitsObs(p, fwd, bwd) {
+						for i, v := range p {
+							want[i].Add(v)
+						}
+					}
+				}
+				b := NewBoard(n)
+				b.NoPerms = true
+				b.SetEdgeClue(OBS_ROW, 0, dir, count)
+				b.TrimByDualObserver()
+				for ci := 0; ci < n; ci++ {
+					got := NewSet(b.Candidates(0, ci)...)
+					if v := b.Get(0, ci); v != EMPTY {
+						got = NewSet(v)
+					}
+					if !got.Equal(want[ci]) {
+						t.Errorf("size %d, %s: cell %d allows %v, want %v", n, o, ci, got.Keys(), want[ci].Keys())
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestOneSidedCluesSolve(t *testing.T) {
+	// Puzzles clued only on the top and left edges, which often have more
+	// than one solution: the heuristics must never remove a value of the
+	// grid the clues came from, and the solvers must find some grid that
+	// fits the clues.
+	rng := rand.New(rand.NewSource(1))
+	for n := 4; n <= 6; n++ {
+		for trial := 0; trial < 5; trial++ {
+			g := randomLatinSquare(n, rng)
+			full := boardFromSolution(g)
+			puzzle := NewBoard(n)
+			for _, o := range full.Observers {
+				if o.Direction == OBS_FWD {
+					puzzle.SetEdgeClue(o.Type, o.Index, o.Direction, o.Count)
+				}
+			}
+			for _, lowMem := range []bool{false, true} {
+				b := puzzle.Clone()
+				b.NoPerms = lowMem
+				err := b.AutoSolve()
+				if err != nil && !errors.Is(err, ErrNeedsGuessing) && !errors.Is(err, ErrAmbiguous) {
+					t.Fatalf("size %d, low memory %v: AutoSolve: %v\n%s", n, lowMem, err, puzzle.ToPuzzleString())
+				}
+				for ri := range g {
+					for ci, v := range g[ri] {
+						if got := b.Get(ri, ci); got == EMPTY && !b.IsAllowed(ri, ci, v) {
+							t.Errorf("size %d, low memory %v: %d was removed from (%d, %d)\n%s", n, lowMem, v, ri, ci, puzzle.ToPuzzleString())
+						}
+					}
+				}
+				b = puzzle.Clone()
+				if lowMem {
+					err = b.SolveLowMemory()
+				} else {
+					err = b.Solve().Err
+				}
+				if err != nil {
+					t.Fatalf("size %d, low memory %v: %v\n%s", n, lowMem, err, puzzle.ToPuzzleString())
+				}
+				if err := b.Solved(); err != nil {
+					t.Errorf("size %d, low memory %v: %v\n%s", n, lowMem, err, b)
+				}
+			}
+		}
+	}
+}
+
+func TestMarkMandatoryLongCascade(t *testing.T) {
+	// In the grid g[r][c] = (r-c) mod n + 1 every diagonal is constant, so
+	// leaving the staircase (n-1, n-1), (n-1, n-2), (n-2, n-2), ..., (1, 0)
+	// empty gives each of its cells the candidates {1, 2}, except the two
+	// ends, which are alone in their columns. Each placement forces the next
+	// cell, so MarkMandatory has to follow a chain of 2n-2 cells, most of it
+	// against the order it sweeps the board in.
+	n := MaxGlyph
+	g := make([][]int, n)
+	for r := range g {
+		g[r] = make([]int, n)
+		for c := range g[r] {
+			g[r][c] = ((r-c)%n+n)%n + 1
+		}
+	}
+	stair := func(r, c int) bool { return r >= 1 && (c == r || c == r-1) }
+	b := NewBoard(n)
+	b.NoPerms = true
+	for r := range g {
+		for c, v := range g[r] {
+			if !stair(r, c) {
+				b.Mark(r, c, v)
+			}
+		}
+	}
+	if b.NumEmpty != 2*n-2 {
+		t.Fatalf("NumEmpty = %d, want %d", b.NumEmpty, 2*n-2)
+	}
+	if !b.MarkMandatory() {
+		t.Fatal("MarkMandatory made no change")
+	}
+	if b.NumEmpty != 0 {
+		t.Fatalf("MarkMandatory left %d cells empty", b.NumEmpty)
+	}
+	for r := range g {
+		for c, v := range g[r] {
+			if got := b.Get(r, c); got != v {
+				t.Fatalf("cell (%d, %d) = %d, want %d", r, c, got, v)
+			}
+		}
+	}
+	if err := b.CheckConsistency(); err != nil {
+		t.Error(err)
+	}
+	if b.MarkMandatory() {
+		t.Error("a second MarkMandatory reported a change")
+	}
+}
+
+func TestSolvePuzzleString(t *testing.T) {
+	for _, name := range bundledPuzzles {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Solve(string(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if want, _ := SolveString(string(data)); got != want {
+			t.Errorf("%s: Solve returned\n%s\nSolveString\n%s", name, got, want)
+		}
+		b, err := BoardFromString(got)
+		if err != nil {
+			t.Fatalf("%s: re-parsing the solution: %v", name, err)
+		}
+		if err := b.Solved(); err != nil {
+			t.Errorf("%s: %v\n%s", name, err, got)
+		}
+	}
+}
+
+func TestBlankPuzzleAmbiguous(t *testing.T) {
+	for n := 2; n <= 9; n++ {
+		border := strings.Repeat(" ", n+1)
+		puzzle := border + "\n" + strings.Repeat(strings.Repeat(" ", n+2)+"\n", n) + border
+		b, err := BoardFromString(puzzle)
+		if err != nil {
+			t.Fatalf("size %d: %v", n, err)
+		}
+		if err := b.Clone().AutoSolve(); !errors.Is(err, ErrAmbiguous) {
+			t.Errorf("size %d: AutoSolve returned %v, want ErrAmbiguous", n, err)
+		}
+		if _, err := SolveString(puzzle); !errors.Is(err, ErrAmbiguous) {
+			t.Errorf("size %d: SolveString returned %v, want ErrAmbiguous", n, err)
+		}
+		// The fallback search still finds one of the many Latin squares.
+		c := b.Clone()
+		if res := c.Solve(); res.Err != nil || c.Solved() != nil {
+			t.Errorf("size %d: Solve returned %v", n, res.Err)
+		}
+	}
+}
+
+func TestApplyTrivialLinesContradiction(t *testing.T) {
+	// The clue 1 needs a 4 at (0, 0), but the row already has one at
+	// (0, 2).
+	b := NewBoard(4)
+	b.SetEdgeClue(OBS_ROW, 0, OBS_FWD, 1)
+	b.Mark(0, 2, 4)
+	if b.ApplyTrivialLines() {
+		t.Error("ApplyTrivialLines reported a change")
+	}
+	if got := b.Get(0, 0); got != EMPTY {
+		t.Errorf("cell (0, 0) = %d, want it left empty", got)
+	}
+	err := b.Clone().AutoSolve()
+	if !errors.Is(err, ErrUnsatisfiable) || !strings.Contains(err.Error(), "row 0 FWD sees 1 needs 4 at (0, 0)") {
+		t.Errorf("AutoSolve returned %v, want a contradiction naming the row 0 clue", err)
+	}
+
+	// A clue of Size whose line already holds a value out of order.
+	b = NewBoard(4)
+	b.SetEdgeClue(OBS_COL, 1, OBS_BWD, 4)
+	b.Mark(3, 1, 2)
+	err = b.AutoSolve()
+	if !errors.Is(err, ErrUnsatisfiable) || !strings.Contains(err.Error(), "col 1 BWD sees 4 needs 1 at (3, 1)") {
+		t.Errorf("AutoSolve returned %v, want a contradiction naming the col 1 clue", err)
+	}
+}
+
+func TestSolveWithStatsStopsAtContradiction(t *testing.T) {
+	b := NewBoard(4)
+	b.SetEdgeClue(OBS_ROW, 0, OBS_FWD, 1)
+	b.Mark(0, 2, 4)
+	want := b.Clone().Solve().Err
+	stats, err := b.SolveWithStats()
+	if !errors.Is(err, ErrUnsatisfiable) || err.Error() != want.Error() {
+		t.Errorf("SolveWithStats returned %v, want %v as Solve does", err, want)
+	}
+	if stats.Guesses != 0 {
+		t.Errorf("SolveWithStats made %d guesses on a contradiction", stats.Guesses)
+	}
+}
+
+func TestAutoSolveOptsErrors(t *testing.T) {
+	blank := "     \n      \n      \n      \n      \n     "
+	for _, guessing := range []bool{false, true} {
+		b, _ := BoardFromString(blank)
+		if err := b.AutoSolveOpts(SolveOptions{AllowGuessing: guessing}); !errors.Is(err, ErrAmbiguous) {
+			t.Errorf("blank board, guessing %v: got %v, want ErrAmbiguous", guessing, err)
+		}
+
+		// A heuristic that claims progress without making any trips the
+		// ErrInternal guard.
+		b = loadPuzzle(t, "problem6.txt")
+		b.Heuristics = []Heuristic{{Name: "Liar", Apply: func(*Board) bool { return true }}}
+		if err := b.AutoSolveOpts(SolveOptions{AllowGuessing: guessing}); !errors.Is(err, ErrInternal) {
+			t.Errorf("lying heuristic, guessing %v: got %v, want ErrInternal", guessing, err)
+		}
+	}
+
+	b := hardBoard8(t)
+	if err := b.AutoSolveOpts(SolveOptions{}); err != ErrNeedsGuessing {
+		t.Errorf("stalled board: got %v, want ErrNeedsGuessing", err)
+	}
+	if err := b.AutoSolveOpts(SolveOptions{AllowGuessing: true}); err != nil || b.Solved() != nil {
+		t.Errorf("stalled board with guessing: got %v", err)
+	}
+}
+
+func TestTrimByTrialWithoutPerms(t *testing.T) {
+	has := func(list []Heuristic) bool {
+		for _, h := range list {
+			if h.Name == "TrimByTrial" {
+				return h.Fallback
+			}
+		}
+		return false
+	}
+	if !has(LowMemoryHeuristics()) {
+		t.Error("LowMemoryHeuristics has no TrimByTrial fallback")
+	}
+	b := loadPuzzle(t, "problem6.txt")
+	if !has(SolveOptions{SkipPerms: true}.heuristics(b)) {
+		t.Error("SkipPerms drops TrimByTrial")
+	}
+
+	puzzle, want := Generate(6, 1)
+	b = NewBoard(6)
+	b.NoPerms = true
+	for _, o := range puzzle.Observers {
+		b.SetEdgeClue(o.Type, o.Index, o.Direction, o.Count)
+	}
+	b.TrimByTrial()
+	if b.Perms != nil {
+		t.Error("TrimByTrial generated Perms")
+	}
+	for ri := range want {
+		for ci, v := range want[ri] {
+			if b.Get(ri, ci) == EMPTY && !b.IsAllowed(ri, ci, v) {
+				t.Errorf("TrimByTrial removed the solution's %d from (%d, %d)", v, ri, ci)
+			}
+		}
+	}
+}
+
+func TestSolveStreamPassesInternalErrors(t *testing.T) {
+	b := loadPuzzle(t, "problem6.txt")
+	b.Heuristics = []Heuristic{{Name: "Liar", Apply: func(*Board) bool { return true }}}
+	boards, errs := b.SolveStream()
+	for range boards {
+	}
+	if err := <-errs; !errors.Is(err, ErrInternal) {
+		t.Errorf("SolveStream with a lying heuristic returned %v, want ErrInternal", err)
+	}
+	if b.NumEmpty == 0 {
+		t.Error("SolveStream searched past an internal error")
+	}
+}
+
+func TestTrimFoundGroupsPair(t *testing.T) {
+	// 2 and 3 can only go in the first two cells of the line, so those
+	// cells lose their other candidates.
+	cands := [][]int{{2, 3, 4}, {2, 3, 5}, {1, 4, 5}, {1, 4, 5}, {1, 4, 5}}
+	blank := strings.Repeat("       \n", 7)
+	for _, col := range []bool{false, true} {
+		b, err := BoardFromString(blank)
+		if err != nil {
+			t.Fatal(err)
+		}
+		at := func(i int) (int, int) {
+			if col {
+				return i, 0
+			}
+			return 0, i
+		}
+		for i, vals := range cands {
+			ri, ci := at(i)
+			b.DisallowOthers(ri, ci, vals)
+		}
+		if !b.TrimFoundGroups(2) {
+			t.Fatalf("col %v: TrimFoundGroups found nothing", col)
+		}
+		for i, want := range [][]int{{2, 3}, {2, 3}, {1, 4, 5}, {1, 4, 5}, {1, 4, 5}} {
+			ri, ci := at(i)
+			if got := b.Candidates(ri, ci); !reflect.DeepEqual(got, want) {
+				t.Errorf("col %v: cell (%d, %d) allows %v, want %v", col, ri, ci, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package towers
+
+import "errors"
+
+// Solve is the simplest way to use the solver: it takes a puzzle in the
+// bordered text format read by BoardFromString and returns the solved board
+// in the same format. It is SolveString under a shorter name; see there for
+// how the puzzle is solved and the errors returned.
+func Solve(puzzle string) (solution string, err error) {
+	return SolveString(puzzle)
+}
+
+// SolveString parses puzzle, in the bordered text format read by
+// BoardFromString, solves it with AutoSolve, falling back to backtracking
+// search if the heuristics get stuck, and returns the solved board in the
+// format produced by ToPuzzleString. It prints nothing, which makes it
+// suitable for embedding, e.g. in a WebAssembly build. Parse errors are
+// returned as is; a puzzle with no solution returns ErrUnsatisfiable and one
+// with several returns ErrAmbiguous, as far as AutoSolve's bounded count of
+// the solutions can tell.
+func SolveString(puzzle string) (string, error) {
+	b, err := BoardFromString(puzzle)
+	if err != nil {
+		return "", err
+	}
+	if err := b.AutoSolve(); err != nil {
+		switch {
+		case errors.Is(err, ErrUnsatisfiable):
+			return "", ErrUnsatisfiable
+		case errors.Is(err, ErrAmbiguous):
+			return "", ErrAmbiguous
+		case errors.Is(err, ErrInternal):
+			return "", err
+		}
+		if err := b.solveStalled(err); err != nil {
+			return "", err
+		}
+	}
+	return b.ToPuzzleString(), nil
+}
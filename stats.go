@@ -0,0 +1,227 @@
+package towers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SolveStats records how a solve went: the wall-clock time taken, the number
+// of AutoSolve rounds, how many times each heuristic made progress (Fired),
+// how many candidates each heuristic removed from Allowed lists (Eliminated)
+// and how many backtracking guesses were needed. FinalTechnique names the
+// heuristic whose step filled the last empty cell, or GuessingTechnique if the
+// search filled it. It is empty if the grid was never completed or was full to
+// begin with. The final step is often an easy one after a hard setup, so it
+// is not the same as the deciding technique.
+type SolveStats struct {
+	Duration       time.Duration
+	Rounds         int
+	Fired          map[string]int
+	Eliminated     map[string]int
+	Guesses        int
+	FinalTechnique string
+}
+
+// NewSolveStats returns an empty SolveStats with its maps allocated.
+func NewSolveStats() *SolveStats {
+	return &SolveStats{
+		Fired:      make(map[string]int),
+		Eliminated: make(map[string]int),
+	}
+}
+
+// track calls heuristic f and returns its result. If s is non-nil, it also
+// records whether f made progress and how many candidates it removed. Note
+// that candidates removed by Mark while placing values are counted too.
+func (s *SolveStats) track(b *Board, name string, f func() bool) bool {
+	if s == nil {
+		return f()
+	}
+	before, empty := b.NumCandidates(), b.NumEmpty
+	fired := f()
+	if fired {
+		s.Fired[name]++
+	}
+	if empty > 0 && b.NumEmpty == 0 {
+		s.FinalTechnique = name
+	}
+	s.Eliminated[name] += before - b.NumCandidates()
+	return fired
+}
+
+// NumCandidates returns the total number of entries in all Allowed lists.
+func (b *Board) NumCandidates() int {
+	n := 0
+	for _, row := range b.Allowed {
+		for _, allowed := range row {
+			n += allowed.Len()
+		}
+	}
+	return n
+}
+
+// SolveWithStats runs AutoSolve, falls back to BruteSolve if the heuristics
+// get stuck, and reports statistics about the solve. As with Solve, an
+// ErrUnsatisfiable or ErrInternal from AutoSolve is returned as is rather
+// than hidden by the search.
+func (b *Board) SolveWithStats() (SolveStats, error) {
+	stats := NewSolveStats()
+	start := time.Now()
+	err := b.autoSolve(context.Background(), stats)
+	if err != nil && !errors.Is(err, ErrUnsatisfiable) && !errors.Is(err, ErrInternal) {
+		err = b.bruteSolve(context.Background(), stats, nil, false)
+		if err == nil {
+			stats.FinalTechnique = GuessingTechnique
+		}
+	}
+	stats.Duration = time.Since(start)
+	return *stats, err
+}
+
+// SolveResult is the full outcome of a Solve call. The embedded SolveStats
+// hold the rounds, the per-heuristic counts and the final technique, and are
+// filled in as far as the solve got even when it fails. NumEmpty is the
+// number of cells still empty at the end, Guessed reports whether
+// backtracking search was needed, and Err is the error that AutoSolve or
+// BruteSolve gave, if any.
+type SolveResult struct {
+	SolveStats
+	Solved   bool
+	NumEmpty int
+	Guessed  bool
+	Err      error
+}
+
+// Solve runs AutoSolve, falls back to BruteSolve if the heuristics get stuck,
+// and reports everything about the solve in a SolveResult. If AutoSolve finds
+// the puzzle has no solution, or stops with ErrInternal, BruteSolve is not
+// tried.
+func (b *Board) Solve() SolveResult {
+	return b.SolveContext(context.Background())
+}
+
+// SolveContext is Solve with cancellation. If ctx is canceled or its deadline
+// passes, the solve stops and the result reports the progress made so far,
+// with Err set to ctx.Err(). The heuristics only stop between steps and the
+// search works on a copy, so the board is left partially filled but
+// consistent, as CheckConsistency checks.
+func (b *Board) SolveContext(ctx context.Context) SolveResult {
+	stats := NewSolveStats()
+	start := time.Now()
+	res := SolveResult{}
+	res.Err = b.autoSolve(ctx, stats)
+	if res.Err != nil && !errors.Is(res.Err, ErrUnsatisfiable) && !errors.Is(res.Err, ErrInternal) {
+		if err := ctx.Err(); err != nil {
+			res.Err = err
+		} else {
+			res.Guessed = true
+			res.Err = b.bruteSolve(ctx, stats, nil, false)
+			if res.Err == nil {
+				stats.FinalTechnique = GuessingTechnique
+			}
+		}
+	}
+	stats.Duration = time.Since(start)
+	res.SolveStats = *stats
+	res.Solved = res.Err == nil
+	res.NumEmpty = b.NumEmpty
+	return res
+}
+
+// GuessingTechnique is the name HeuristicHistogram and FinalTechnique use for
+// backtracking search.
+const GuessingTechnique = "guessing"
+
+// A HeuristicHistogram tallies solver activity over a set of puzzles. Deciding
+// counts, for each technique, the puzzles for which it was the deciding one:
+// the latest in the heuristic order that made progress, or GuessingTechnique
+// if the heuristics got stuck and search was needed. Fired sums each
+// heuristic's SolveStats.Fired over all puzzles. Unsolved counts the puzzles
+// that had no solution.
+type HeuristicHistogram struct {
+	Puzzles  int
+	Unsolved int
+	Deciding map[string]int
+	Fired    map[string]int
+}
+
+// NewHeuristicHistogram returns an empty HeuristicHistogram with its maps
+// allocated.
+func NewHeuristicHistogram() *HeuristicHistogram {
+	return &HeuristicHistogram{
+		Deciding: make(map[string]int),
+		Fired:    make(map[string]int),
+	}
+}
+
+// Add solves b with Solve and adds the outcome to the histogram.
+func (h *HeuristicHistogram) Add(b *Board) {
+	res := b.Solve()
+	h.Puzzles++
+	if !res.Solved {
+		h.Unsolved++
+	}
+	for name, n := range res.Fired {
+		h.Fired[name] += n
+	}
+	if deciding := b.decidingTechnique(res.Fired, res.Guessed); deciding != "" {
+		h.Deciding[deciding]++
+	}
+}
+
+// decidingTechnique returns GuessingTechnique if guessed is set, and otherwise
+// the latest heuristic in the order b runs them that has a nonzero count in
+// fired. The result is empty if no heuristic fired.
+func (b *Board) decidingTechnique(fired map[string]int, guessed bool) string {
+	if guessed {
+		return GuessingTechnique
+	}
+	deciding := ""
+	for _, hr := range b.activeHeuristics() {
+		if fired[hr.Name] > 0 {
+			deciding = hr.Name
+		}
+	}
+	return deciding
+}
+
+// String lists the deciding counts, most common first, followed by the fired
+// counts in the same form.
+func (h *HeuristicHistogram) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d puzzles, %d unsolved\n", h.Puzzles, h.Unsolved)
+	for _, sec := range []struct {
+		title  string
+		counts map[string]int
+	}{{"Deciding technique", h.Deciding}, {"Times fired", h.Fired}} {
+		fmt.Fprintf(&sb, "%s:\n", sec.title)
+		names := make([]string, 0, len(sec.counts))
+		for name := range sec.counts {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if sec.counts[names[i]] != sec.counts[names[j]] {
+				return sec.counts[names[i]] > sec.counts[names[j]]
+			}
+			return names[i] < names[j]
+		})
+		for _, name := range names {
+			fmt.Fprintf(&sb, "  %-24s %d\n", name, sec.counts[name])
+		}
+	}
+	return sb.String()
+}
+
+// Histogram solves each of boards and returns a HeuristicHistogram of how
+// they were solved. The boards are solved in place.
+func Histogram(boards []*Board) *HeuristicHistogram {
+	h := NewHeuristicHistogram()
+	for _, b := range boards {
+		h.Add(b)
+	}
+	return h
+}
@@ -0,0 +1,46 @@
+package towers
+
+import (
+	"context"
+	"errors"
+)
+
+// SolveStream solves the board in the background the same way as Solve,
+// sending a snapshot of the board on the first channel after each AutoSolve
+// round and after each guess made by the backtracking search. Snapshots are
+// clones, so they can be read while solving carries on. When solving ends, the
+// error, if any, is sent on the second channel and both channels are closed.
+// The caller must keep receiving snapshots until the first channel is closed,
+// and must not touch the board until then.
+func (b *Board) SolveStream() (<-chan *Board, <-chan error) {
+	boards := make(chan *Board)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(boards)
+		prev := b.Progress
+		snapshot := func(s *Board) {
+			c := s.Clone()
+			c.Progress = prev
+			boards <- c
+		}
+		b.Progress = func(round, numEmpty int) {
+			if prev != nil {
+				prev(round, numEmpty)
+			}
+			snapshot(b)
+		}
+		err := b.autoSolve(context.Background(), nil)
+		b.Progress = prev
+		if err != nil && !errors.Is(err, ErrUnsatisfiable) && !errors.Is(err, ErrInternal) {
+			err = b.bruteSolve(context.Background(), nil, snapshot, false)
+			if err == nil {
+				snapshot(b)
+			}
+		}
+		if err != nil {
+			errs <- err
+		}
+	}()
+	return boards, errs
+}
@@ -0,0 +1,57 @@
+package towers
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// svgCell is the width and height of one grid cell in the SVG output, in
+// pixels. The edge clues sit in a margin one cell wide around the grid.
+const svgCell = 40
+
+// ToSVG writes a standalone SVG image of the board to w. The grid lines are
+// drawn with a heavier outer frame, edge clues are placed outside the frame
+// and filled cells show their values. Empty cells are left blank, so the same
+// function renders both an unsolved puzzle and its solution.
+func (b *Board) ToSVG(w io.Writer) error {
+	var sb strings.Builder
+	dim := (b.Size + 2) * svgCell
+	fmt.Fprintf(&sb, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n", dim, dim, dim, dim)
+	fmt.Fprintf(&sb, "<rect width=\"%d\" height=\"%d\" fill=\"white\"/>\n", dim, dim)
+	lo := svgCell
+	hi := (b.Size + 1) * svgCell
+	for i := 0; i <= b.Size; i++ {
+		pos := (i + 1) * svgCell
+		width := 1
+		if i == 0 || i == b.Size {
+			width = 3
+		}
+		fmt.Fprintf(&sb, "<line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" stroke=\"black\" stroke-width=\"%d\"/>\n", lo, pos, hi, pos, width)
+		fmt.Fprintf(&sb, "<line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" stroke=\"black\" stroke-width=\"%d\"/>\n", pos, lo, pos, hi, width)
+	}
+	text := func(col, row int, s string) {
+		if s == " " {
+			return
+		}
+		x := col*svgCell + svgCell/2
+		y := row*svgCell + svgCell/2
+		fmt.Fprintf(&sb, "<text x=\"%d\" y=\"%d\" font-family=\"sans-serif\" font-size=\"%d\" text-anchor=\"middle\" dominant-baseline=\"central\">%s</text>\n", x, y, svgCell/2, s)
+	}
+	for i := 0; i < b.Size; i++ {
+		text(i+1, 0, b.ObsChar(OBS_COL, i, OBS_FWD))
+		text(i+1, b.Size+1, b.ObsChar(OBS_COL, i, OBS_BWD))
+		text(0, i+1, b.ObsChar(OBS_ROW, i, OBS_FWD))
+		text(b.Size+1, i+1, b.ObsChar(OBS_ROW, i, OBS_BWD))
+	}
+	for ri := 0; ri < b.Size; ri++ {
+		for ci := 0; ci < b.Size; ci++ {
+			if b.Get(ri, ci) != EMPTY {
+				text(ci+1, ri+1, b.CharAt(ri, ci))
+			}
+		}
+	}
+	sb.WriteString("</svg>\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
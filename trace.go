@@ -0,0 +1,66 @@
+package towers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// solveTrace is the serialized form of a solve for SolveToJSON: every move in
+// the order it was made, then the solved grid.
+type solveTrace struct {
+	Moves    []SolveStep
+	Solution [][]int
+}
+
+// SolveToJSON solves the board one step at a time and returns the moves as
+// JSON, for a frontend to replay. Each move is a SolveStep, as returned by
+// Hint, placing a value or removing candidates. When no technique applies, the
+// board is solved by search and the emptiest cell is filled from that
+// solution as a move with Technique GuessingTechnique, after which logic
+// resumes. The payload ends with the solved grid. Returns an error, leaving
+// the board partly solved, if it has no solution.
+func (b *Board) SolveToJSON() ([]byte, error) {
+	trace := solveTrace{Moves: make([]SolveStep, 0)}
+	var sol *Board
+	for {
+		s, err := b.Hint()
+		if errors.Is(err, ErrAlreadySolved) {
+			break
+		}
+		if err != nil {
+			if sol == nil {
+				sol = b.Clone()
+				sol.Verbose = false
+				if err := sol.BruteSolve(); err != nil {
+					return nil, err
+				}
+			}
+			s = b.guessStep(sol)
+		}
+		b.ApplyStep(s)
+		trace.Moves = append(trace.Moves, *s)
+	}
+	trace.Solution = b.CopyGrid()
+	return json.Marshal(trace)
+}
+
+// guessStep returns a move filling the empty cell with the fewest candidates
+// with its value in the solved board sol.
+func (b *Board) guessStep(sol *Board) *SolveStep {
+	bestR, bestC := -1, -1
+	for _, cell := range b.EmptyCells() {
+		ri, ci := cell[0], cell[1]
+		if bestR < 0 || b.CandidateCount(ri, ci) < b.CandidateCount(bestR, bestC) {
+			bestR, bestC = ri, ci
+		}
+	}
+	v := sol.Get(bestR, bestC)
+	return &SolveStep{
+		Technique:   GuessingTechnique,
+		Row:         bestR,
+		Col:         bestC,
+		Value:       v,
+		Description: fmt.Sprintf("search puts %d at cell (%d, %d)", v, bestR, bestC),
+	}
+}
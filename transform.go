@@ -0,0 +1,119 @@
+package towers
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// Rotate90 returns a copy of the board turned a quarter turn clockwise. Row i
+// becomes column Size-1-i, read in the same direction, and column j becomes
+// row j, read the other way, so the edge clues move and swap directions to
+// match. Grid, Given and Allowed are carried over cell by cell.
+func (b *Board) Rotate90() *Board {
+	n := b.Size
+	return b.transform(
+		func(ri, ci int) (int, int) {
+			return ci, n - 1 - ri
+		},
+		func(o Observer) Observer {
+			if o.Type == OBS_ROW {
+				o.Type = OBS_COL
+				o.Index = n - 1 - o.Index
+			} else {
+				o.Type = OBS_ROW
+				o.Direction = 1 - o.Direction
+			}
+			return o
+		},
+	)
+}
+
+// Reflect returns a mirror image of the board, flipped left to right. Each
+// row's clues swap ends and each column moves to the mirrored position.
+func (b *Board) Reflect() *Board {
+	n := b.Size
+	return b.transform(
+		func(ri, ci int) (int, int) {
+			return ri, n - 1 - ci
+		},
+		func(o Observer) Observer {
+			if o.Type == OBS_ROW {
+				o.Direction = 1 - o.Direction
+			} else {
+				o.Index = n - 1 - o.Index
+			}
+			return o
+		},
+	)
+}
+
+// transform builds a new board from b, moving the contents of each cell to
+// the position given by cell and replacing each observer with the one given
+// by obs. Perms is shared with b if it has been generated, or regenerated in
+// full if b's has been compacted, and each line's permutation list is
+// recomputed and trimmed to the new Allowed lists.
+func (b *Board) transform(cell func(ri, ci int) (int, int), obs func(Observer) Observer) *Board {
+	c := NewBoard(b.Size)
+	for ri := 0; ri < b.Size; ri++ {
+		for ci := 0; ci < b.Size; ci++ {
+			nr, nc := cell(ri, ci)
+			c.Grid[nr][nc] = b.Grid[ri][ci]
+			c.Given[nr][nc] = b.Given[ri][ci]
+			c.Allowed[nr][nc] = b.Allowed[ri][ci].Clone()
+		}
+	}
+	c.NumEmpty = b.NumEmpty
+	for _, o := range b.Observers {
+		no := obs(*o)
+		c.AddObserver(&no)
+	}
+	c.Verbose = b.Verbose
+	c.Heuristics = b.Heuristics
+	c.NoPerms = b.NoPerms
+	if b.Perms != nil {
+		c.Perms = b.fullPerms()
+		c.PopulateRowColPerms()
+		c.TrimPermsFromAllowed()
+	}
+	return c
+}
+
+// Canonical returns whichever of the eight rotations and reflections of the
+// board has the smallest ToPuzzleString, so boards that are the same puzzle
+// up to symmetry have the same canonical form. The receiver is not modified.
+func (b *Board) Canonical() *Board {
+	var best *Board
+	bestStr := ""
+	for _, c := range []*Board{b.Rotate90(), b.Reflect()} {
+		for i := 0; i < 4; i++ {
+			s := c.ToPuzzleString()
+			if best == nil || s < bestStr {
+				best, bestStr = c, s
+			}
+			c = c.Rotate90()
+		}
+	}
+	return best
+}
+
+// ClueHash returns a 64-bit FNV-1a hash of the board's size and edge clues,
+// so puzzles can be used as cache keys. Filled cells, candidates and the solve
+// progress are ignored, so a puzzle hashes the same before and after solving.
+// The clues are read from Observers, and a clue of 0 counts as no clue. The
+// hash is not symmetry-invariant; hash the Canonical board for that.
+func (b *Board) ClueHash() uint64 {
+	clues := make([]uint32, b.Size*4)
+	for _, o := range b.Observers {
+		if o.Count == 0 {
+			continue
+		}
+		clues[b.obsIndex(o.Type, o.Index, o.Direction)] = uint32(o.Count)
+	}
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(b.Size))
+	for _, c := range clues {
+		buf = binary.LittleEndian.AppendUint32(buf, c)
+	}
+	h := fnv.New64a()
+	h.Write(buf)
+	return h.Sum64()
+}
@@ -0,0 +1,52 @@
+package towers
+
+import "testing"
+
+// transformBoards returns the bundled puzzles, one of them part solved so
+// that Grid and Allowed are exercised too.
+func transformBoards(t *testing.T) []*Board {
+	var out []*Board
+	for _, name := range bundledPuzzles {
+		out = append(out, loadPuzzle(t, name))
+	}
+	b := loadPuzzle(t, "problem6.txt")
+	b.MarkMandatory()
+	b.TrimPermsFromAllowed()
+	b.TrimAllowedFromPerms()
+	return append(out, b)
+}
+
+func TestRotate90FourTimes(t *testing.T) {
+	for i, b := range transformBoards(t) {
+		r := b.Rotate90()
+		if r.Equals(b) {
+			t.Errorf("board %d: a quarter turn changed nothing", i)
+		}
+		got := r.Rotate90().Rotate90().Rotate90()
+		if !got.EqualsStrict(b) {
+			t.Errorf("board %d: four quarter turns give\n%s\nwant\n%s", i, got, b)
+		}
+		if !b.Reflect().Reflect().EqualsStrict(b) {
+			t.Errorf("board %d: reflecting twice doesn't give the board back", i)
+		}
+	}
+}
+
+func TestCanonicalSymmetryInvariant(t *testing.T) {
+	for i, b := range transformBoards(t) {
+		before := b.ToPuzzleString()
+		want := b.Canonical().ToPuzzleString()
+		if b.ToPuzzleString() != before {
+			t.Fatalf("board %d: Canonical modified the receiver", i)
+		}
+		variants := []*Board{b.Reflect()}
+		for r := b.Rotate90(); len(variants) < 8; r = r.Rotate90() {
+			variants = append(variants, r, r.Reflect())
+		}
+		for j, v := range variants {
+			if got := v.Canonical().ToPuzzleString(); got != want {
+				t.Errorf("board %d, variant %d: canonical form\n%s\nwant\n%s", i, j, got, want)
+			}
+		}
+	}
+}